@@ -0,0 +1,111 @@
+package nes
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ZipMagic  = []byte("PK\x03\x04")
+	GzipMagic = []byte{0x1F, 0x8B}
+	RomExts   = []string{".nes", ".fds", ".unf", ".unif", ".nsf"}
+)
+
+// 路径中 # 之后的部分用于指定压缩包内的文件 例如 roms/合集.zip#魂斗罗.nes
+// 文件名本身可能含有 # 只有完整路径不存在或 # 之前是压缩包时才拆分 压缩包按魔数判断 与后缀无关
+func SplitArchivePath(path string) (string, string) {
+	index := strings.LastIndex(path, "#")
+	if index < 0 {
+		return path, ""
+	}
+	if _, err := os.Stat(path); err == nil && !IsArchiveFile(path[:index]) {
+		return path, ""
+	}
+	return path[:index], path[index+1:]
+}
+
+func IsArchive(data []byte) bool {
+	return bytes.HasPrefix(data, ZipMagic) || bytes.HasPrefix(data, GzipMagic)
+}
+
+// 只读取文件开头的魔数 文件不存在时返回 false
+func IsArchiveFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head := make([]byte, len(ZipMagic))
+	n, _ := io.ReadFull(file, head)
+	return IsArchive(head[:n])
+}
+
+// 压缩包内的 rom 使用 压缩包路径#文件名 生成存档等文件 同一个压缩包内的 rom 互不影响
+func EntryPath(path, entry string) string {
+	if entry == "" {
+		return path
+	}
+	return path + "#" + filepath.Base(entry)
+}
+
+// 根据魔数判断是否为压缩包 是则解压出 rom 数据 否则原样返回
+func Unpack(data []byte, entry string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, ZipMagic):
+		return UnpackZip(data, entry)
+	case bytes.HasPrefix(data, GzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	}
+	return data, nil
+}
+
+// 没有指定文件时使用第一个 rom 后缀的文件
+func UnpackZip(data []byte, entry string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range reader.File {
+		if entry != "" && file.Name != entry {
+			continue
+		}
+		if entry == "" && !IsRomExt(file.Name) {
+			continue
+		}
+		item, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer item.Close()
+		return io.ReadAll(item)
+	}
+	if entry != "" {
+		return nil, fmt.Errorf("not find %s in zip", entry)
+	}
+	return nil, errors.New("not find rom in zip")
+}
+
+func IsRomExt(name string) bool {
+	return HasExt(name, RomExts)
+}
+
+func HasExt(name string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, item := range exts {
+		if ext == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package nes
+
+// 目前没有 2A03 的 APU 只输出 Sunsoft 5B Namco 163 FDS 等扩展音频
+// 每条指令结束后按 SampleRate 对 mapper 的输出采样 前端通过 Console.ReadSamples 取走
+const (
+	SampleRate  = 44100
+	SampleLimit = SampleRate / 2 // 前端没有及时取走时最多保留的采样数 超出的丢弃
+	AudioVolume = 0.5
+)
+
+type Audio struct {
+	Clock   int // 每个 cpu 周期增加 SampleRate 达到 CPUFreq 时采样一次
+	Samples []float32
+}
+
+func NewAudio() *Audio {
+	return &Audio{Samples: make([]float32, 0, SampleLimit)}
+}
+
+func (a *Audio) Step(cycles int, mapper MapperAudio) {
+	a.Clock += cycles * SampleRate
+	for a.Clock >= CPUFreq {
+		a.Clock -= CPUFreq
+		if len(a.Samples) < SampleLimit {
+			a.Samples = append(a.Samples, mapper.Audio()*AudioVolume)
+		}
+	}
+}
+
+// 取走目前为止的采样 单声道 范围 -1-1
+func (a *Audio) Read() []float32 {
+	res := append([]float32(nil), a.Samples...)
+	a.Samples = a.Samples[:0]
+	return res
+}
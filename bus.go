@@ -1,8 +1,4 @@
-package main
-
-import (
-	"github.com/hajimehoshi/ebiten/v2"
-)
+package nes
 
 type Bus struct {
 	CPU       *CPU
@@ -12,20 +8,40 @@ type Bus struct {
 	Input2    *Input
 	Mapper    Mapper
 	RAM       []byte
-	CurrBuff  *ebiten.Image // 用来实现逐帧渲染的 使用 PPU.Frame 也可以
+	CurrFrame uint64 // 用来实现逐帧渲染的 记录上次完成的帧数
+	Lag       bool   // 上一帧没有读取过手柄 即延迟帧
+	LagCount  uint64 // 延迟帧的总数
+	Audio     *Audio `state:"-"` // 扩展音频的采样 不属于模拟状态
+}
+
+// rom 读取失败或 mapper 不支持时返回错误
+func NewBus(path string, patches ...string) (*Bus, error) {
+	cartridge, err := LoadCartridge(path, patches...)
+	if err != nil {
+		return nil, err
+	}
+	return NewBusCartridge(cartridge)
 }
 
-func NewBus(path string) *Bus {
-	// 暂时 2p没有输入，只有 1p 有输入
-	bus := &Bus{Cartridge: LoadCartridge(path), RAM: make([]byte, 2*1024), Input2: NewInput(),
-		Input1: NewInput(ebiten.KeyK, ebiten.KeyJ, ebiten.KeyF, ebiten.KeyH, ebiten.KeyW, ebiten.KeyS, ebiten.KeyA, ebiten.KeyD)}
-	bus.Mapper = NewMapper(bus)
+// 使用已经读取的卡带 运行中卡带会被修改 需要保留原始数据时传入 Clone 的结果
+func NewBusCartridge(cartridge *Cartridge) (*Bus, error) {
+	// 按键状态由前端通过 SetButtons 设置
+	bus := &Bus{Cartridge: cartridge, RAM: make([]byte, 2*1024), Input1: NewInput(), Input2: NewInput(),
+		Audio: NewAudio()}
+	var err error
+	bus.Mapper, err = NewMapper(bus)
+	if err != nil {
+		return nil, err
+	}
 	bus.CPU = NewCPU(bus)
 	bus.PPU = NewPPU(bus)
-	return bus
+	return bus, nil
 }
 
 func (c *Bus) Reset() {
+	if reset, ok := c.Mapper.(MapperReset); ok {
+		reset.Reset()
+	}
 	c.CPU.Reset()
 }
 
@@ -36,15 +52,28 @@ func (c *Bus) CpuStep() int {
 	for i := 0; i < ppuCycles; i++ {
 		c.PPU.Step()
 	}
+	if stepper, ok := c.Mapper.(MapperStepper); ok {
+		for i := 0; i < cpuCycles; i++ {
+			stepper.Step()
+		}
+	}
+	if audio, ok := c.Mapper.(MapperAudio); ok {
+		c.Audio.Step(cpuCycles, audio)
+	}
 	return cpuCycles
 }
 
 // 绘制 ppu的一帧画面
 func (c *Bus) PpuStep() {
-	for c.CurrBuff == c.PPU.FrontBuff {
+	c.Input1.Polled, c.Input2.Polled = false, false
+	for c.CurrFrame == c.PPU.FrameDone {
 		c.CpuStep()
 	}
-	c.CurrBuff = c.PPU.FrontBuff
+	c.CurrFrame = c.PPU.FrameDone
+	c.Lag = !c.Input1.Polled && !c.Input2.Polled
+	if c.Lag {
+		c.LagCount++
+	}
 }
 
 // 60帧每秒每帧的运行量
@@ -55,11 +84,7 @@ func (c *Bus) FrameStep() {
 	}
 }
 
-func (c *Bus) Buffer() *ebiten.Image {
-	return c.PPU.FrontBuff
-}
-
-func (c *Bus) UpdateInput() {
-	c.Input1.Step()
-	c.Input2.Step()
+// 最近完成的一帧 RGBA 数据
+func (c *Bus) Buffer() []uint8 {
+	return c.PPU.FrontRGBA
 }
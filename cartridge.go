@@ -1,18 +1,43 @@
-package main
+package nes
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const NESMagic = 0x1A53454E
 
+// NSF 音乐文件不是卡带 识别出来返回 ErrNSF 而不是 ErrBadMagic
+var NSFMagic = []byte("NESM\x1A")
+
+func IsNSF(data []byte) bool {
+	return bytes.HasPrefix(data, NSFMagic)
+}
+
 type Cartridge struct {
-	PRG    []byte // PRG-ROM 程序代码
-	CHR    []byte // CHR-ROM 图块数据
-	Mapper uint8  // mapper 类型
-	Mirror uint8  // mirroring 类型
+	PRG       []byte   // PRG-ROM 程序代码
+	CHR       []byte   // CHR-ROM 图块数据
+	Mapper    uint16   // mapper 类型
+	Submapper uint8    // NES 2.0 的 submapper 类型 iNES 格式为 0
+	Mirror    uint8    // mirroring 类型
+	Path      string   // rom 文件路径 用于生成存档文件 压缩包内的 rom 为 压缩包路径#文件名
+	Disk      [][]byte // FDS 磁盘的每一面 此时 PRG 为 BIOS
+	Name      string   // 游戏名称 只有 UNIF 格式有
+	Battery   bool     // 是否有电池供电的存档
+	Region    uint8    // 制式 RegionNTSC 等
+	PrgRam    int      // PRG-RAM 大小 包含带电池的部分
+	ChrRam    int      // CHR-RAM 大小
+	Hash      [20]byte // 解压并应用补丁后整个文件的 sha1 用于校验即时存档
+	Warnings  []error  // 不影响加载的问题 例如补丁的 CRC 不匹配 由调用方决定如何提示
+	Fixes     []string // 根据游戏数据库修正的文件头信息
+	NoSave    bool     // 录像期间不读写存档与磁盘差异文件 保证回放结果相同
 }
 
 type NESHeader struct {
@@ -24,36 +49,183 @@ type NESHeader struct {
 	Unused   [8]byte
 }
 
-func LoadCartridge(path string) *Cartridge {
-	file, err := os.Open(path)
-	HandleErr(err)
-	defer file.Close()
+// 支持直接读取 zip 与 gzip 压缩的 rom 存档与补丁等文件以 EntryPath 为准
+// 与 rom 同名的补丁以及 patches 指定的补丁会在解析之前依次应用
+// 失败时返回 ErrBadMagic ErrTruncated 等错误 由调用方决定如何提示
+func LoadCartridge(path string, patches ...string) (*Cartridge, error) {
+	path, entry := SplitArchivePath(path)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := Unpack(raw, entry)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(FindPatches(path, entry, IsArchive(raw)), patches...)
+	path = EntryPath(path, entry)
+	data, warnings, err := ApplyPatches(data, patches)
+	if err != nil {
+		return nil, err
+	}
+	var cartridge *Cartridge
+	switch {
+	case IsNSF(data):
+		return nil, ErrNSF
+	case IsFDS(data):
+		cartridge, err = LoadFDS(path, data)
+	case IsUNIF(data):
+		cartridge, err = LoadUNIF(path, data)
+	default:
+		cartridge, err = LoadNES(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cartridge.Hash = sha1.Sum(data)
+	cartridge.Warnings = append(warnings, cartridge.Warnings...)
+	return cartridge, nil
+}
 
+func LoadNES(path string, data []byte) (*Cartridge, error) {
+	file := bytes.NewReader(data)
 	header := NESHeader{}
-	err = binary.Read(file, binary.LittleEndian, &header)
-	HandleErr(err)
+	err := binary.Read(file, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: nes header", ErrTruncated)
+	}
 	if header.Magic != NESMagic {
-		panic("not nes file")
+		return nil, fmt.Errorf("%w: not nes file", ErrBadMagic)
+	}
+	if header.PRGNum == 0 {
+		return nil, fmt.Errorf("%w: prg rom size is 0", ErrTruncated)
 	}
 
+	nes2 := header.Control2&0x0C == 0x08
+	// iNES 1.0 的 12-15 字节应为 0 不为 0 时多半是 "DiskDude!" 等工具写入的垃圾 第 7 字节也不可信
+	garbage := !nes2 && binary.LittleEndian.Uint32(header.Unused[4:]) != 0
 	mapper1 := header.Control1 >> 4
 	mapper2 := header.Control2 >> 4
-	mapper := mapper1 | mapper2<<4
+	if garbage {
+		mapper2 = 0
+	}
+	mapper := uint16(mapper1) | uint16(mapper2)<<4
+	submapper := uint8(0)
+	if nes2 { // NES 2.0 格式 Unused[0] 存储了 mapper 高位与 submapper
+		mapper |= uint16(header.Unused[0]&0x0F) << 8
+		submapper = header.Unused[0] >> 4
+	}
 	mirror1 := header.Control1 & 1
 	mirror2 := (header.Control1 >> 3) & 1
 	mirror := mirror1 | mirror2<<1
+	cartridge := &Cartridge{Mapper: mapper, Submapper: submapper, Mirror: mirror, Path: path,
+		Battery: header.Control1&2 != 0}
+	if garbage {
+		cartridge.Warnings = append(cartridge.Warnings,
+			fmt.Errorf("ines header bytes 12-15 %q are not zero, ignore mapper high nibble", header.Unused[4:]))
+	}
+	// 文件头是否给出了 RAM 大小与制式
+	exact := nes2
+	if nes2 { // NES 2.0 的 RAM 大小为 64 << n 与制式
+		cartridge.PrgRam = NES2RamSize(header.Unused[2]&0x0F) + NES2RamSize(header.Unused[2]>>4)
+		cartridge.ChrRam = NES2RamSize(header.Unused[3]&0x0F) + NES2RamSize(header.Unused[3]>>4)
+		cartridge.Region = header.Unused[4] & 3
+	} else {
+		if !garbage && header.Unused[0] != 0 { // iNES 1.0 的第 8 字节为 8k 为单位的 PRG-RAM 大小
+			cartridge.PrgRam = int(header.Unused[0]) * 8 * 1024
+			exact = true
+		}
+		if header.CHRNum == 0 {
+			cartridge.ChrRam = 8 * 1024
+		}
+	}
 	if header.Control1&4 == 4 { // 这部分信息不需要直接舍弃
 		_, err = file.Seek(512, 1)
-		HandleErr(err)
+		if err != nil {
+			return nil, err
+		}
 	}
 	prg := make([]byte, int(header.PRGNum)*16*1024)
 	_, err = io.ReadFull(file, prg)
-	HandleErr(err)
-	chr := make([]byte, 8*1024) // 可能 tile 没有直接存储是后面加载的 预留 8k
+	if err != nil {
+		return nil, fmt.Errorf("%w: prg rom", ErrTruncated)
+	}
+	rom := prg
+	chr := make([]byte, 0)
 	if header.CHRNum > 0 {
 		chr = make([]byte, int(header.CHRNum)*8*1024)
 		_, err = io.ReadFull(file, chr)
-		HandleErr(err)
+		if err != nil {
+			return nil, fmt.Errorf("%w: chr rom", ErrTruncated)
+		}
+		rom = append(append([]byte(nil), prg...), chr...)
+	}
+	info, err := FindGameInfo(path, rom)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil { // 文件头可能有误 以数据库为准
+		cartridge.Fixes = cartridge.ApplyGameInfo(info, exact)
+	}
+	if len(chr) == 0 { // 可能 tile 没有直接存储是后面加载的 至少预留 8k
+		chr = make([]byte, Max(cartridge.ChrRam, 8*1024))
+	}
+	cartridge.PRG, cartridge.CHR = prg, chr
+	return cartridge, nil
+}
+
+// 深拷贝 运行中 mapper 会修改镜像 CHR-RAM 与磁盘 重新开机时使用拷贝
+func (c *Cartridge) Clone() *Cartridge {
+	res := *c
+	res.PRG = append([]byte(nil), c.PRG...)
+	res.CHR = append([]byte(nil), c.CHR...)
+	res.Disk = make([][]byte, len(c.Disk))
+	for i, side := range c.Disk {
+		res.Disk[i] = append([]byte(nil), side...)
+	}
+	return &res
+}
+
+// 与游戏数据库相同 计算 PRG 与 CHR-ROM 的 crc32 CHR-RAM 的卡带只计算 PRG
+func (c *Cartridge) RomCrc32() uint32 {
+	rom := append([]byte(nil), c.PRG...)
+	if c.ChrRam == 0 {
+		rom = append(rom, c.CHR...)
+	}
+	return crc32.ChecksumIEEE(rom)
+}
+
+func NES2RamSize(shift uint8) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+// 存档与 rom 同名 后缀为 .sav
+func (c *Cartridge) SavePath() string {
+	return strings.TrimSuffix(c.Path, filepath.Ext(c.Path)) + ".sav"
+}
+
+// 读取存档到 data 中 存档不存在时保持原样
+func (c *Cartridge) LoadSave(data []byte) {
+	if c.NoSave {
+		return
+	}
+	save, err := os.ReadFile(c.SavePath())
+	if err != nil {
+		return
+	}
+	copy(data, save)
+}
+
+// 存档失败不影响运行 只打印错误
+func (c *Cartridge) WriteSave(data []byte) {
+	if c.NoSave {
+		return
+	}
+	err := os.WriteFile(c.SavePath(), data, 0644)
+	if err != nil {
+		fmt.Printf("write save err %v\n", err)
 	}
-	return &Cartridge{PRG: prg, CHR: chr, Mapper: mapper, Mirror: mirror}
 }
@@ -0,0 +1,22 @@
+// nes-headless 与 nes headless 相同 但不依赖 ebiten 可以在没有图形库的环境编译运行
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"nes/headless"
+)
+
+func main() {
+	err := headless.Command(os.Args[1:], os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+
+	"nes"
+)
+
+const AudioLatency = nes.SampleRate / 10 // 最多缓存 100ms 的采样 超出时丢弃最旧的
+
+// 模拟器每帧写入采样 ebiten 在自己的 goroutine 中读取 转换为 16bit 立体声
+type AudioStream struct {
+	Lock    sync.Mutex
+	Samples []float32
+}
+
+func NewAudioPlayer(stream *AudioStream) (*audio.Player, error) {
+	player, err := audio.NewContext(nes.SampleRate).NewPlayer(stream)
+	if err != nil {
+		return nil, err
+	}
+	player.SetBufferSize(50 * time.Millisecond)
+	player.Play()
+	return player, nil
+}
+
+func (s *AudioStream) Push(samples []float32) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	s.Samples = append(s.Samples, samples...)
+	if len(s.Samples) > AudioLatency {
+		s.Samples = append(s.Samples[:0], s.Samples[len(s.Samples)-AudioLatency:]...)
+	}
+}
+
+// 没有采样时输出静音 暂停与没有扩展音频的游戏不会阻塞播放
+func (s *AudioStream) Read(buff []byte) (int, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	count := len(buff) / 4
+	for i := 0; i < count; i++ {
+		val := int16(0)
+		if i < len(s.Samples) {
+			sample := s.Samples[i]
+			if sample > 1 {
+				sample = 1
+			} else if sample < -1 {
+				sample = -1
+			}
+			val = int16(sample * 0x7FFF)
+		}
+		binary.LittleEndian.PutUint16(buff[i*4:], uint16(val))
+		binary.LittleEndian.PutUint16(buff[i*4+2:], uint16(val))
+	}
+	if count > len(s.Samples) {
+		count = len(s.Samples)
+	}
+	s.Samples = append(s.Samples[:0], s.Samples[count:]...)
+	return len(buff) / 4 * 4, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"nes"
+	"nes/headless"
+)
+
+const Usage = `usage: nes <command> [flags] <rom> [patch...]
+
+commands:
+  run       运行游戏 (默认命令 可以直接传入 rom 路径)
+  info      显示 rom 信息
+  disasm    反汇编 PRG 空间
+  headless  无界面运行若干帧 输出画面哈希 png 与 RAM
+
+使用 nes <command> -h 查看各命令的参数
+rom 可以是 zip/gzip 压缩包 使用 包路径#文件名 指定包内文件
+
+运行时按键:
+  WSAD 方向 FH Select Start JK B A
+  R 重启 P 调整调色盘(调色盘不会实时更新需要使用P触发更新) M 运行模式切换 N debug运行时推动执行 L FDS 换面
+  Backspace 按住倒带 O 显示帧数/延迟帧数
+  Shift+F1-F10 存档到栏位 F1-F10 预览栏位 再次按下或回车读档
+  TAS 模式(M 切换): Space 播放 N 前进一帧 Backspace 后退一帧 Enter 跳到光标所在帧
+    上下 PageUp PageDown 滚轮移动光标 游戏按键或点击表格修改输入
+    0-9 读取书签 Shift+0-9 设置书签 Ctrl+S 保存录像 绿色为绿区 红色为延迟帧
+`
+
+// https://www.bilibili.com/video/BV1Uv4y1v7T9
+// https://www.nesdev.org/wiki/Nesdev_Wiki
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(Usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = RunCommand(os.Args[2:])
+	case "info":
+		err = InfoCommand(os.Args[2:])
+	case "disasm":
+		err = DisasmCommand(os.Args[2:])
+	case "headless":
+		err = HeadlessCommand(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(Usage)
+	default: // 兼容直接传入 rom 路径
+		err = RunCommand(os.Args[1:])
+	}
+	if errors.Is(err, flag.ErrHelp) { // -h 已经打印了参数说明
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// 所有命令都需要至少一个 rom 路径 之后的参数为补丁
+func LoadConsole(set *flag.FlagSet, args []string) (*nes.Console, string, error) {
+	if len(args) == 0 {
+		set.Usage()
+		return nil, "", fmt.Errorf("%s: missing rom path", set.Name())
+	}
+	console := nes.NewConsole()
+	if err := console.LoadROM(args[0], args[1:]...); err != nil {
+		return nil, "", fmt.Errorf("load %s err %w", args[0], err)
+	}
+	for _, warning := range console.Bus.Cartridge.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", warning)
+	}
+	if fixes := console.Bus.Cartridge.Fixes; len(fixes) > 0 {
+		fmt.Fprintf(os.Stderr, "gamedb fix: %s\n", strings.Join(fixes, ", "))
+	}
+	return console, args[0], nil
+}
+
+var RegionNames = map[string]uint8{"ntsc": nes.RegionNTSC, "pal": nes.RegionPAL, "multi": nes.RegionMulti,
+	"dendy": nes.RegionDendy}
+
+func RunCommand(args []string) error {
+	set := flag.NewFlagSet("run", flag.ContinueOnError)
+	scale := set.Int("scale", 3, "游戏画面缩放倍数")
+	fullscreen := set.Bool("fullscreen", false, "全屏运行")
+	region := set.String("region", "auto", "制式 auto ntsc pal dendy 目前只影响帧率")
+	palette := set.String("palette", "", ".pal 调色盘文件")
+	noDebugPanel := set.Bool("no-debug-panel", false, "隐藏右侧调试面板")
+	savestate := set.String("savestate", "", "启动时读取的即时存档")
+	movie := set.String("movie", "", "启动时回放的 FM2 录像")
+	record := set.String("record", "", "录制 FM2 录像 退出时保存 与 --savestate 一起使用时从存档开始录制")
+	args, err := headless.ParseArgs(set, args)
+	if err != nil {
+		return err
+	}
+	if *scale < 1 {
+		return fmt.Errorf("bad scale %d", *scale)
+	}
+	console, path, err := LoadConsole(set, args)
+	if err != nil {
+		return err
+	}
+	if *region != "auto" {
+		value, ok := RegionNames[strings.ToLower(*region)]
+		if !ok {
+			return fmt.Errorf("unknown region %s", *region)
+		}
+		console.Bus.Cartridge.Region = value
+	}
+	if *palette != "" {
+		data, err := os.ReadFile(*palette)
+		if err != nil {
+			return err
+		}
+		colors, err := nes.LoadPalette(data)
+		if err != nil {
+			return err
+		}
+		console.SetPalette(colors)
+	}
+	if *savestate != "" {
+		file, err := os.Open(*savestate)
+		if err != nil {
+			return err
+		}
+		err = console.LoadState(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("load state %s err %w", *savestate, err)
+		}
+	}
+	if *movie != "" && *record != "" {
+		return fmt.Errorf("--movie and --record can not be used together")
+	}
+	ebiten.SetWindowSize(WindowSize(*scale, !*noDebugPanel))
+	ebiten.SetWindowTitle(filepath.Base(path))
+	ebiten.SetFullscreen(*fullscreen)
+	if console.Bus.Cartridge.Region == nes.RegionPAL || console.Bus.Cartridge.Region == nes.RegionDendy {
+		ebiten.SetTPS(50)
+	} else {
+		ebiten.SetTPS(nes.Fps)
+	}
+	game := NewGame(console, *scale, !*noDebugPanel)
+	if _, err = NewAudioPlayer(game.Audio); err != nil { // 没有声音也可以运行
+		fmt.Fprintf(os.Stderr, "audio err %v\n", err)
+	}
+	game.RecordPath = *record
+	if *movie != "" {
+		data, err := headless.LoadMovie(*movie)
+		if err != nil {
+			return err
+		}
+		if err = data.Start(console); err != nil {
+			return fmt.Errorf("start movie %s err %w", *movie, err)
+		}
+		game.StartMovie(data, false)
+		if err = data.CheckRom(console); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			game.ShowMessage("%v", err)
+		}
+	}
+	if *record != "" {
+		data, err := nes.NewMovie(console, *savestate != "")
+		if err != nil {
+			return err
+		}
+		game.StartMovie(data, true)
+	}
+	err = ebiten.RunGame(game)
+	if saveErr := game.SaveMovie(game.RecordPath); err == nil { // 出错退出时也保存已经录制的部分
+		err = saveErr
+	}
+	return err
+}
+
+var MirrorNames = []string{"horizontal", "vertical", "single0", "single1"}
+
+func InfoCommand(args []string) error {
+	set := flag.NewFlagSet("info", flag.ContinueOnError)
+	args, err := headless.ParseArgs(set, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		set.Usage()
+		return fmt.Errorf("%s: missing rom path", set.Name())
+	}
+	// 不创建 mapper 不支持的 mapper 与没有 BIOS 的 FDS 也可以查看
+	path := args[0]
+	cartridge, err := nes.LoadCartridge(path, args[1:]...)
+	if err != nil {
+		return fmt.Errorf("load %s err %w", path, err)
+	}
+	regions := []string{"NTSC", "PAL", "multi", "Dendy"}
+	fmt.Printf("file:      %s\n", path)
+	if cartridge.Name != "" {
+		fmt.Printf("name:      %s\n", cartridge.Name)
+	}
+	fmt.Printf("mapper:    %d.%d\n", cartridge.Mapper, cartridge.Submapper)
+	fmt.Printf("prg:       %dk\n", len(cartridge.PRG)/1024)
+	fmt.Printf("chr:       %dk\n", len(cartridge.CHR)/1024)
+	fmt.Printf("prg ram:   %d\n", cartridge.PrgRam)
+	fmt.Printf("chr ram:   %d\n", cartridge.ChrRam)
+	if int(cartridge.Mirror) < len(MirrorNames) {
+		fmt.Printf("mirror:    %s\n", MirrorNames[cartridge.Mirror])
+	}
+	fmt.Printf("region:    %s\n", regions[cartridge.Region&3])
+	fmt.Printf("battery:   %v\n", cartridge.Battery)
+	if len(cartridge.Disk) > 0 {
+		fmt.Printf("fds sides: %d\n", len(cartridge.Disk))
+	}
+	if len(cartridge.Disk) == 0 { // FDS 的 PRG 为 BIOS
+		fmt.Printf("crc32:     %08X\n", cartridge.RomCrc32())
+	}
+	for _, fix := range cartridge.Fixes {
+		fmt.Printf("gamedb:    %s\n", fix)
+	}
+	for _, warning := range cartridge.Warnings {
+		fmt.Printf("warning:   %v\n", warning)
+	}
+	return nil
+}
+
+// 地址参数为十六进制 可以带 $ 或 0x 前缀
+func ParseAddr(value string) (uint16, error) {
+	value = strings.TrimPrefix(value, "$")
+	value = strings.TrimPrefix(strings.ToLower(value), "0x")
+	res, err := strconv.ParseUint(value, 16, 16)
+	return uint16(res), err
+}
+
+func DisasmCommand(args []string) error {
+	set := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	start := set.String("start", "$8000", "起始地址")
+	end := set.String("end", "$FFFA", "结束地址 默认到中断向量之前")
+	args, err := headless.ParseArgs(set, args)
+	if err != nil {
+		return err
+	}
+	startAddr, err := ParseAddr(*start)
+	if err != nil {
+		return err
+	}
+	endAddr, err := ParseAddr(*end)
+	if err != nil {
+		return err
+	}
+	if endAddr > 0xFFFA { // 避免越过 $FFFF 后回绕
+		endAddr = 0xFFFA
+	}
+	console, _, err := LoadConsole(set, args)
+	if err != nil {
+		return err
+	}
+	codes := console.Bus.CPU.Disassemble(startAddr, endAddr)
+	addrs := make([]int, 0, len(codes))
+	for addr := range codes {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		fmt.Println(codes[uint16(addr)])
+	}
+	return nil
+}
+
+func HeadlessCommand(args []string) error {
+	return headless.Command(args, os.Stdout)
+}
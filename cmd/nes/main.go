@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+
+	"nes"
+)
+
+const (
+	Width  = nes.Width
+	Height = nes.Height
+)
+
+const (
+	ModeNormal = 0
+	ModeFrame  = 1
+	ModeInst   = 2
+	ModeTAS    = 3
+)
+
+var (
+	ModeNames = []string{"NORMAL", "FRAME", "INST", "TAS"}
+	// 1p 按键 顺序 A B Select Start Up Down Left Right 暂时 2p没有输入
+	Keys = []ebiten.Key{ebiten.KeyK, ebiten.KeyJ, ebiten.KeyF, ebiten.KeyH, ebiten.KeyW, ebiten.KeyS, ebiten.KeyA, ebiten.KeyD}
+)
+
+type Game struct {
+	Console       *nes.Console
+	Option        *ebiten.DrawImageOptions
+	PaletteIdx    uint8
+	TileMaps      []*ebiten.Image
+	Screen        *ebiten.Image // 游戏画面 每帧从 Console.Framebuffer 上传
+	Mode          uint8
+	Scale         int         // 游戏画面缩放倍数
+	DebugPanel    bool        // 是否在右侧显示调试面板
+	Picker        *SlotPicker // 存档栏位选择界面 不为 nil 时暂停运行
+	Rewind        *nes.Rewind // 按住 Backspace 倒带
+	Movie         *nes.Movie  // 正在录制或回放的录像
+	Recording     bool
+	MovieStart    int      // 录像第 0 帧对应的倒带帧数
+	Command       uint8    // 下一帧开始时执行的录像命令 重启 换面等
+	RecordPath    string   // 录像的保存路径
+	TAS           *nes.TAS // 最近一次 TAS 编辑
+	Cursor        int      // TAS 表格中光标所在的帧
+	Playing       bool     // TAS 模式下是否连续运行
+	Overlay       bool     // 是否显示帧数与延迟帧数
+	Message       string   // 画面左下角的提示信息
+	MessageFrames int      // 提示信息剩余显示帧数
+	Audio         *AudioStream
+	//CodeLines   []uint16
+	//CodeMap     map[uint16]string
+	//CodeLineIdx map[uint16]int
+}
+
+func NewGame(console *nes.Console, scale int, debugPanel bool) *Game {
+	tileMaps := []*ebiten.Image{ebiten.NewImage(128, 128), ebiten.NewImage(128, 128)}
+	// 必须有无影响读接口
+	//codeMap := console.Bus.CPU.Disassemble(0x8000, 0xFFFF)
+	//codeLines := make([]uint16, 0)
+	//for line := range codeMap {
+	//	codeLines = append(codeLines, line)
+	//}
+	//sort.Slice(codeLines, func(i, j int) bool {
+	//	return codeLines[i] < codeLines[j]
+	//})
+	//codeLineIdx := make(map[uint16]int)
+	//for idx, line := range codeLines {
+	//	codeLineIdx[line] = idx
+	//}
+	return &Game{Console: console, Option: &ebiten.DrawImageOptions{}, PaletteIdx: 0, TileMaps: tileMaps, Mode: ModeNormal,
+		Screen: ebiten.NewImage(Width, Height), Scale: scale, DebugPanel: debugPanel,
+		Rewind: nes.NewRewind(nes.RewindInterval, nes.RewindLimit), Audio: &AudioStream{}}
+}
+
+func (g *Game) Update() error {
+	g.Console.ReadSamples() // 只播放正常运行的声音 丢弃倒带 逐帧等产生的采样
+	g.UpdateInput()
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) && g.Mode != ModeTAS { // 重启 在下一帧开始时执行 以便录像记录
+		g.Command |= nes.MovieSoftReset
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		g.Overlay = !g.Overlay
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) { // 调整调色盘
+		g.PaletteIdx = (g.PaletteIdx + 1) % 8
+		g.UpdateTileMap()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		if err := g.SwitchMode(); err != nil {
+			return err
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) && g.Mode != ModeTAS { // FDS 换面
+		g.Command |= nes.MovieFDSSelect
+	}
+	if g.Mode != ModeTAS { // TAS 使用自己的书签
+		g.UpdateSlots()
+	}
+	if g.MessageFrames > 0 {
+		g.MessageFrames--
+	}
+	if g.Picker != nil {
+		return nil
+	}
+	// 按不同的模式执行
+	switch g.Mode {
+	case ModeNormal: // 正常执行
+		if ebiten.IsKeyPressed(ebiten.KeyBackspace) {
+			ok, err := g.Rewind.Back(g.Console)
+			if ok {
+				g.ShowMessage("<< rewind")
+			}
+			return err
+		}
+		if err := g.StepFrame(); err != nil {
+			return err
+		}
+		g.Audio.Push(g.Console.ReadSamples())
+	case ModeFrame: // debug 逐帧允许
+		if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+			return g.StepFrame()
+		}
+	case ModeInst: // debug 逐指令允许
+		if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+			g.Rewind.Clear() // 快照只能落在帧的边界上
+			g.Console.Bus.CpuStep()
+		}
+	case ModeTAS:
+		return g.UpdateTAS()
+	}
+	return nil
+}
+
+// MODE 切换 进入与离开 TAS 时需要在录像与编辑之间转换
+func (g *Game) SwitchMode() error {
+	if g.Mode == ModeTAS {
+		g.LeaveTAS()
+	}
+	g.Mode = (g.Mode + 1) % uint8(len(ModeNames))
+	if g.Mode == ModeTAS {
+		return g.EnterTAS()
+	}
+	return nil
+}
+
+func (g *Game) UpdateInput() {
+	buttons := uint8(0)
+	for i, key := range Keys {
+		if ebiten.IsKeyPressed(key) {
+			buttons |= 1 << i
+		}
+	}
+	g.Console.SetButtons(0, buttons)
+}
+
+func (g *Game) UpdateTileMap() {
+	bus := g.Console.Bus
+	for table := 0; table < 2; table++ {
+		for tileY := uint16(0); tileY < 16; tileY++ {
+			for tileX := uint16(0); tileX < 16; tileX++ {
+				offset := (tileY*16 + tileX) * 8 * 2 // 一共过了tileY*16 + tileX 个Tile，每个Tile 8*8 需要 8*2 byte
+				for row := uint16(0); row < 8; row++ {
+					// 每行8个像素由2byte组成 i 是第几个tile表(一共2个) 一共8行，所以另外一个byte需要偏移8
+					tileHi := bus.PPU.Read(0x1000*uint16(table)+offset+row, false)
+					tileLo := bus.PPU.Read(0x1000*uint16(table)+offset+row+8, false)
+					for col := uint16(0); col < 8; col++ {
+						// 拼接高位与地位获取索引 获取颜色
+						i := (tileHi&0x01)<<1 | (tileLo & 0x01)
+						tileHi >>= 1
+						tileLo >>= 1 // 之所以 7-col是因为 这里是从低位开始计算的，每次位移抹除的也是低位
+						g.TileMaps[table].Set(int(tileX*8+(7-col)), int(tileY*8+row), bus.PPU.Colors[bus.PPU.Palette[g.PaletteIdx*4+i]])
+					}
+				}
+			}
+		}
+	}
+}
+
+func (g *Game) Draw(screen *ebiten.Image) {
+	bus := g.Console.Bus
+	screen.Fill(colornames.Blue)
+	// 绘制游戏画面
+	g.Option.GeoM.Reset()
+	g.Option.GeoM.Scale(float64(g.Scale), float64(g.Scale))
+	g.Screen.WritePixels(g.Console.Framebuffer())
+	screen.DrawImage(g.Screen, g.Option)
+	if g.Picker != nil {
+		g.DrawPicker(screen)
+	}
+	if g.MessageFrames > 0 {
+		ebitenutil.DebugPrintAt(screen, g.Message, 4, Height*g.Scale-16)
+	}
+	if g.Overlay {
+		g.DrawOverlay(screen)
+	}
+	if g.Mode == ModeTAS {
+		g.DrawTAS(screen)
+		return
+	}
+	if !g.DebugPanel {
+		return
+	}
+	panelX := Width * g.Scale // 调试面板在游戏画面右侧
+	// 绘制 CPU 状态  字母宽 6 高 16
+	buff := &strings.Builder{}
+	buff.WriteString("STATUS:")
+	WriteStatus(buff, bus.CPU.N, "N")
+	WriteStatus(buff, bus.CPU.V, "V")
+	WriteStatus(buff, bus.CPU.U, "U")
+	WriteStatus(buff, bus.CPU.B, "B")
+	WriteStatus(buff, bus.CPU.D, "D")
+	WriteStatus(buff, bus.CPU.I, "I")
+	WriteStatus(buff, bus.CPU.Z, "Z")
+	WriteStatus(buff, bus.CPU.C, "C")
+	buff.WriteString(fmt.Sprintf("\nPC: $%04X\nA: $%02X\nX: $%02X\nY: $%02X\nSP: $%04X\nMODE: %s LAG: %d",
+		bus.CPU.PC, bus.CPU.A, bus.CPU.X, bus.CPU.Y, bus.CPU.PC, ModeNames[g.Mode], bus.LagCount))
+	ebitenutil.DebugPrintAt(screen, buff.String(), panelX, 0)
+	// 绘制汇编部分
+	//buff.Reset()
+	//_, ok := g.CodeLineIdx[bus.CPU.PC]
+	//if !ok {
+	//	panic(fmt.Sprintf("not find pc of %d", bus.CPU.PC))
+	//}
+	//for i := 0; i < 29; i++ {
+	//	buff.WriteString("$XXXX\n")
+	//}
+	code := bus.CPU.DisassembleCode(29)
+	ebitenutil.DebugPrintAt(screen, code, panelX, 115)
+	// 绘制调色盘
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 4; j++ {
+			x := float32(panelX + i*32 + 2 + j*7)
+			clr := bus.PPU.Colors[bus.PPU.Palette[i*4+j]]
+			vector.DrawFilledRect(screen, x, 583, 7, 7, clr, false)
+		}
+	}
+	vector.StrokeRect(screen, float32(panelX)+float32(g.PaletteIdx*32)+1, 582, 30, 9, 2, colornames.White, false)
+	// 绘制 tileMap
+	g.Option.GeoM.Reset()
+	g.Option.GeoM.Translate(float64(panelX), 592)
+	screen.DrawImage(g.TileMaps[0], g.Option) // spriteTile
+	g.Option.GeoM.Reset()
+	g.Option.GeoM.Translate(float64(panelX+128), 592)
+	screen.DrawImage(g.TileMaps[1], g.Option) // bgTile
+}
+
+// 游戏画面右上角显示帧数与延迟帧数 延迟帧显示 LAG
+func (g *Game) DrawOverlay(screen *ebiten.Image) {
+	text := fmt.Sprintf("%d/%d", g.Rewind.Frame, g.Console.LagCount())
+	if g.Console.Lag() {
+		text += " LAG"
+	}
+	if g.Movie != nil && g.Recording {
+		text += " REC"
+	} else if g.Movie != nil {
+		text += " PLAY"
+	}
+	ebitenutil.DebugPrintAt(screen, text, Width*g.Scale-(len(text)+1)*6, 0)
+}
+
+func WriteStatus(buff *strings.Builder, flag uint8, name string) {
+	if flag == 0 {
+		buff.WriteString(fmt.Sprintf(" (%s)", name))
+	} else {
+		buff.WriteString(fmt.Sprintf(" [%s]", name))
+	}
+}
+
+func (g *Game) Layout(_, _ int) (int, int) {
+	return WindowSize(g.Scale, g.DebugPanel)
+}
+
+// 调试面板宽 Width 高度至少需要 Height*3
+func WindowSize(scale int, debugPanel bool) (int, int) {
+	if !debugPanel {
+		return Width * scale, Height * scale
+	}
+	return Width*scale + Width, nes.Max(Height*scale, Height*3)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+
+	"nes"
+)
+
+// 运行一帧 先执行重启等命令 录像时记录输入 回放时使用录像中的输入
+// 录像的帧号由倒带的帧数得到 倒带后继续录制会覆盖之后的输入
+func (g *Game) StepFrame() error {
+	command := g.Command
+	g.Command = 0
+	frame := g.Rewind.Frame - g.MovieStart
+	var err error
+	switch {
+	case g.Movie != nil && g.Recording:
+		err = g.Movie.Record(g.Console, frame, command)
+	case g.Movie != nil && frame < len(g.Movie.Frames):
+		command = g.Movie.Frames[frame].Command
+		err = g.Movie.Apply(g.Console, frame)
+	default:
+		if g.Movie != nil {
+			g.Movie = nil
+			g.Console.SetMovieMode(false)
+			g.ShowMessage("movie end")
+		}
+		err = nes.ApplyCommand(g.Console, command)
+	}
+	if err != nil {
+		return err
+	}
+	if command != 0 {
+		if err = g.Rewind.Mark(g.Console); err != nil {
+			return err
+		}
+	}
+	if err = g.Rewind.Step(g.Console); err != nil {
+		return err
+	}
+	if g.Movie != nil && g.Recording {
+		g.Movie.SetLag(frame, g.Console.Lag())
+	}
+	return nil
+}
+
+// 从当前状态开始录像或回放 之前的倒带记录不再有效
+func (g *Game) StartMovie(movie *nes.Movie, recording bool) {
+	g.Movie, g.Recording = movie, recording
+	g.Rewind.Clear()
+	g.MovieStart = g.Rewind.Frame
+}
+
+// 保存录制的录像 使用过 TAS 时保存编辑后的录像
+func (g *Game) SaveMovie(path string) error {
+	movie := g.Movie
+	if g.TAS != nil {
+		movie = g.TAS.Movie
+	} else if !g.Recording {
+		return nil
+	}
+	if movie == nil || path == "" {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = movie.WriteFM2(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
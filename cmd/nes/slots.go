@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+
+	"nes"
+)
+
+// F1-F10 对应栏位 1-10
+var SlotKeys = []ebiten.Key{ebiten.KeyF1, ebiten.KeyF2, ebiten.KeyF3, ebiten.KeyF4, ebiten.KeyF5, ebiten.KeyF6,
+	ebiten.KeyF7, ebiten.KeyF8, ebiten.KeyF9, ebiten.KeyF10}
+
+const MessageFrames = 120 // 提示信息显示 2 秒
+
+// 读档前预览各栏位的缩略图 打开时暂停运行
+type SlotPicker struct {
+	Slots  []*nes.Slot
+	Thumbs []*ebiten.Image // 没有缩略图的栏位为 nil
+	Index  int             // 当前选中的栏位 1-10
+}
+
+func NewSlotPicker(console *nes.Console, index int) *SlotPicker {
+	picker := &SlotPicker{Index: index}
+	for i := 1; i <= nes.SlotCount; i++ {
+		slot, err := console.ReadSlot(i)
+		if err != nil {
+			fmt.Printf("read slot %d err %v\n", i, err)
+		}
+		picker.Slots = append(picker.Slots, slot)
+		if slot.Thumb != nil {
+			picker.Thumbs = append(picker.Thumbs, ebiten.NewImageFromImage(slot.Thumb))
+		} else {
+			picker.Thumbs = append(picker.Thumbs, nil)
+		}
+	}
+	return picker
+}
+
+func (g *Game) ShowMessage(format string, args ...interface{}) {
+	g.Message = fmt.Sprintf(format, args...)
+	g.MessageFrames = MessageFrames
+}
+
+// Shift+F 保存 F 打开选择界面 再次按下同一个 F 或回车读取 Esc 取消
+func (g *Game) UpdateSlots() {
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	for i, key := range SlotKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		index := i + 1
+		switch {
+		case shift:
+			if err := g.Console.SaveSlot(index); err != nil {
+				g.ShowMessage("save slot %d err %v", index, err)
+			} else {
+				g.ShowMessage("saved slot %d", index)
+			}
+			g.Picker = nil
+		case g.Picker != nil && g.Picker.Index == index:
+			g.LoadSlot(index)
+		default:
+			g.Picker = NewSlotPicker(g.Console, index)
+		}
+		return
+	}
+	if g.Picker == nil {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowLeft) {
+		g.Picker.Index = (g.Picker.Index+nes.SlotCount-2)%nes.SlotCount + 1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowRight) {
+		g.Picker.Index = g.Picker.Index%nes.SlotCount + 1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowUp) || inpututil.IsKeyJustPressed(ebiten.KeyArrowDown) {
+		g.Picker.Index = (g.Picker.Index+nes.SlotCount/2-1)%nes.SlotCount + 1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.LoadSlot(g.Picker.Index)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.Picker = nil
+	}
+}
+
+func (g *Game) LoadSlot(index int) {
+	g.Picker = nil
+	if g.Movie != nil { // 录像的输入只对应录像开始时的状态
+		g.ShowMessage("can not load slot during movie")
+		return
+	}
+	if slot, err := g.Console.ReadSlot(index); err == nil && slot.Empty() {
+		g.ShowMessage("slot %d is empty", index)
+		return
+	}
+	if err := g.Console.LoadSlot(index); err != nil {
+		g.ShowMessage("load slot %d err %v", index, err)
+		return
+	}
+	g.Rewind.Clear()
+	g.ShowMessage("loaded slot %d", index)
+}
+
+// 5x2 排列的缩略图 覆盖在游戏画面上
+func (g *Game) DrawPicker(screen *ebiten.Image) {
+	picker := g.Picker
+	width, height := float32(Width*g.Scale), float32(Height*g.Scale)
+	vector.DrawFilledRect(screen, 0, 0, width, height, colornames.Black, false)
+	cellW, cellH := width/5, height/2
+	scale := float64(cellW-8) / nes.ThumbWidth
+	for i, slot := range picker.Slots {
+		x, y := float32(i%5)*cellW, float32(i/5)*cellH
+		if thumb := picker.Thumbs[i]; thumb != nil {
+			g.Option.GeoM.Reset()
+			g.Option.GeoM.Scale(scale, scale)
+			g.Option.GeoM.Translate(float64(x+4), float64(y+20))
+			screen.DrawImage(thumb, g.Option)
+		}
+		text := fmt.Sprintf("F%d empty", slot.Index)
+		if !slot.Empty() {
+			text = fmt.Sprintf("F%d %s", slot.Index, slot.Time.Format("01-02 15:04:05"))
+		}
+		ebitenutil.DebugPrintAt(screen, text, int(x)+4, int(y)+2)
+		if slot.Index == picker.Index {
+			vector.StrokeRect(screen, x+2, y+2, cellW-4, cellH-4, 2, colornames.Yellow, false)
+		}
+	}
+	ebitenutil.DebugPrintAt(screen, "<- -> select  Enter load  Esc cancel", 4, int(height)-16)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+
+	"nes"
+)
+
+// 输入表格 每行一帧 字母宽 6 高 16
+// >   帧号 RLDUTSBA RLDUTSBA 书签
+const (
+	RollRowHeight = 16
+	RollCharWidth = 6
+	RollFrameCols = 8 // 当前帧标记与帧号占用的字符数
+)
+
+var (
+	BookmarkKeys = []ebiten.Key{ebiten.Key0, ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4, ebiten.Key5,
+		ebiten.Key6, ebiten.Key7, ebiten.Key8, ebiten.Key9}
+	GreenzoneColor = color.RGBA{G: 0x50, A: 0xFF}
+	LagColor       = color.RGBA{R: 0x70, A: 0xFF}
+)
+
+// 录像没有指定保存路径时与 rom 同名
+func MoviePath(console *nes.Console) string {
+	path := console.Bus.Cartridge.Path
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".fm2"
+}
+
+// 从当前帧开始编辑 没有录像时从当前状态开始一个新的录像
+func (g *Game) EnterTAS() error {
+	movie, frame := g.Movie, g.Rewind.Frame-g.MovieStart
+	if movie == nil {
+		var err error
+		if movie, err = nes.NewMovie(g.Console, true); err != nil {
+			return err
+		}
+		frame = 0
+	}
+	tas, err := nes.NewTAS(g.Console, movie, frame)
+	if err != nil {
+		return err
+	}
+	g.TAS, g.Cursor, g.Playing, g.Command = tas, frame, false, 0
+	if g.RecordPath == "" {
+		g.RecordPath = MoviePath(g.Console)
+	}
+	return nil
+}
+
+// 离开 TAS 后从当前帧继续 录制时覆盖之后的输入 否则回放编辑后的录像
+func (g *Game) LeaveTAS() {
+	recording := g.Recording
+	g.StartMovie(g.TAS.Movie, recording)
+	g.MovieStart = g.Rewind.Frame - g.TAS.Frame
+}
+
+// 游戏暂停 Space 播放 N 前进一帧 Backspace 后退一帧 Enter 跳到光标所在帧
+// 上下 PageUp PageDown 滚轮移动光标 按键或点击表格修改输入 数字读取书签 Shift+数字设置书签 Ctrl+S 保存
+func (g *Game) UpdateTAS() error {
+	tas := g.TAS
+	rows := g.RollRows()
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowUp) {
+		g.Cursor--
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowDown) {
+		g.Cursor++
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		g.Cursor -= rows
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		g.Cursor += rows
+	}
+	_, wheel := ebiten.Wheel()
+	g.Cursor -= int(wheel * 3)
+	if g.Cursor < 0 {
+		g.Cursor = 0
+	}
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl)
+	for i, key := range Keys {
+		if !ctrl && inpututil.IsKeyJustPressed(key) {
+			if err := tas.Toggle(g.Console, g.Cursor, 0, uint8(i)); err != nil {
+				return err
+			}
+		}
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		if frame, player, button, ok := g.RollCell(ebiten.CursorPosition()); ok {
+			if err := tas.Toggle(g.Console, frame, player, button); err != nil {
+				return err
+			}
+		}
+	}
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	for i, key := range BookmarkKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		if shift {
+			if err := tas.SetBookmark(g.Console, i); err != nil {
+				return err
+			}
+			g.ShowMessage("set bookmark %d at frame %d", i, tas.Frame)
+			continue
+		}
+		ok, err := tas.LoadBookmark(g.Console, i)
+		if err != nil {
+			return err
+		}
+		if ok {
+			g.Cursor = tas.Frame
+			g.ShowMessage("load bookmark %d", i)
+		}
+	}
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		if err := g.SaveMovie(g.RecordPath); err != nil {
+			g.ShowMessage("save movie err %v", err)
+		} else {
+			g.ShowMessage("saved %s", filepath.Base(g.RecordPath))
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		g.Playing = !g.Playing
+	}
+	target := tas.Frame
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		target, g.Playing = g.Cursor, false
+	case inpututil.IsKeyJustPressed(ebiten.KeyN) || g.Playing:
+		target++
+		g.Cursor = target
+	case inpututil.IsKeyJustPressed(ebiten.KeyBackspace):
+		target--
+		g.Cursor = target
+	}
+	return tas.Seek(g.Console, target)
+}
+
+// 表格占用调试面板 没有调试面板时覆盖在游戏画面左侧
+func (g *Game) RollX() int {
+	if g.DebugPanel {
+		return Width * g.Scale
+	}
+	return 0
+}
+
+// 最下面两行显示状态
+func (g *Game) RollRows() int {
+	_, height := WindowSize(g.Scale, g.DebugPanel)
+	return height/RollRowHeight - 3
+}
+
+// 光标所在帧显示在表格中间
+func (g *Game) RollTop() int {
+	return nes.Max(g.Cursor-g.RollRows()/2, 0)
+}
+
+func (g *Game) RollCell(x, y int) (int, int, uint8, bool) {
+	col := (x-g.RollX())/RollCharWidth - RollFrameCols - 1
+	row := y/RollRowHeight - 1
+	if x < g.RollX() || col < 0 || col >= 17 || col == 8 || row < 0 || row >= g.RollRows() {
+		return 0, 0, 0, false
+	}
+	return g.RollTop() + row, col / 9, uint8(7 - col%9), true
+}
+
+func (g *Game) DrawTAS(screen *ebiten.Image) {
+	tas := g.TAS
+	x0 := g.RollX()
+	rows := g.RollRows()
+	if !g.DebugPanel {
+		vector.DrawFilledRect(screen, 0, 0, float32((RollFrameCols+20)*RollCharWidth),
+			float32((rows+3)*RollRowHeight), colornames.Black, false)
+	}
+	ebitenutil.DebugPrintAt(screen, "   FRAME RLDUTSBA RLDUTSBA", x0, 0)
+	bookmarks := make(map[int]string)
+	for i, bookmark := range tas.Bookmarks {
+		if bookmark != nil {
+			bookmarks[bookmark.Frame] += fmt.Sprint(i)
+		}
+	}
+	top := g.RollTop()
+	buff := &strings.Builder{}
+	for row := 0; row < rows; row++ {
+		frame := top + row
+		y := (row + 1) * RollRowHeight
+		width := float32((RollFrameCols + 18) * RollCharWidth)
+		if tas.InGreenzone(frame) {
+			clr := GreenzoneColor
+			if frame < len(tas.Movie.Lag) && tas.Movie.Lag[frame] {
+				clr = LagColor
+			}
+			vector.DrawFilledRect(screen, float32(x0), float32(y), width, RollRowHeight, clr, false)
+		}
+		if frame == g.Cursor {
+			vector.StrokeRect(screen, float32(x0), float32(y), width, RollRowHeight, 1, colornames.White, false)
+		}
+		data := nes.MovieFrame{}
+		if frame < len(tas.Movie.Frames) {
+			data = tas.Movie.Frames[frame]
+		}
+		mark := " "
+		if frame == tas.Frame {
+			mark = ">"
+		}
+		// FormatFM2Frame 为 |命令|1p|2p|| 只取按键部分
+		buttons := strings.Split(nes.FormatFM2Frame(data), "|")
+		buff.WriteString(fmt.Sprintf("%s%7d %s %s %s\n", mark, frame, buttons[2], buttons[3], bookmarks[frame]))
+	}
+	ebitenutil.DebugPrintAt(screen, buff.String(), x0, RollRowHeight)
+	status := fmt.Sprintf("frame %d/%d lag %d rerecord %s", tas.Frame, len(tas.Movie.Frames), tas.Movie.LagCount(),
+		tas.Movie.Header["rerecordCount"])
+	if g.Playing {
+		status += " PLAY"
+	}
+	ebitenutil.DebugPrintAt(screen, status, x0, (rows+1)*RollRowHeight)
+}
@@ -0,0 +1,188 @@
+package nes
+
+import (
+	"errors"
+	"image/color"
+	"io"
+)
+
+const (
+	Width  = 256
+	Height = 240
+	Fps    = 60
+)
+
+var ErrNoRom = errors.New("no rom loaded")
+
+// 对外提供的模拟器接口 前端与工具只需要使用 Console
+// 需要更细粒度控制时可以直接访问 Bus
+// 所有状态都属于实例 不同的 Console 可以在不同的 goroutine 中同时运行 单个 Console 不能并发使用
+type Console struct {
+	Bus       *Bus
+	Colors    [64]color.RGBA // 重新加载 rom 时保留
+	Rom       *Cartridge     // 没有运行过的卡带 重新开机时使用 不再读取文件
+	MovieMode bool           // 正在录制或回放录像
+}
+
+func NewConsole() *Console {
+	return &Console{Colors: DefaultPalette}
+}
+
+func (c *Console) SetPalette(colors [64]color.RGBA) {
+	c.Colors = colors
+	if c.Bus != nil {
+		c.Bus.PPU.Colors = colors
+		c.Bus.PPU.UpdateRGBA()
+	}
+}
+
+// 读取 rom 并重建整个总线 失败时保留之前的状态
+func (c *Console) LoadROM(path string, patches ...string) error {
+	cartridge, err := LoadCartridge(path, patches...)
+	if err != nil {
+		return err
+	}
+	cartridge.NoSave = c.MovieMode
+	rom := cartridge.Clone()
+	bus, err := NewBusCartridge(cartridge)
+	if err != nil {
+		return err
+	}
+	if len(rom.PRG) == 0 { // FDS 的 BIOS 在创建 mapper 时加载
+		rom.PRG = cartridge.PRG
+	}
+	c.SetBus(bus)
+	c.Rom = rom
+	return nil
+}
+
+func (c *Console) SetBus(bus *Bus) {
+	bus.PPU.Colors = c.Colors
+	bus.PPU.UpdateRGBA()
+	c.Bus = bus
+}
+
+// 重新开机 与 Reset 不同 所有内存都恢复到开机时的状态
+// 使用内存中的卡带 不重新读取 rom 补丁 录像期间也不读取存档与磁盘差异文件
+func (c *Console) Power() error {
+	if c.Bus == nil || c.Rom == nil {
+		return ErrNoRom
+	}
+	cartridge := c.Rom.Clone()
+	cartridge.Region = c.Bus.Cartridge.Region // 前端可能指定了制式
+	cartridge.NoSave = c.MovieMode
+	bus, err := NewBusCartridge(cartridge)
+	if err != nil {
+		return err
+	}
+	c.SetBus(bus)
+	return nil
+}
+
+// 录像期间存档与磁盘差异文件不参与运行 结束录像后恢复
+func (c *Console) SetMovieMode(on bool) {
+	c.MovieMode = on
+	if c.Bus != nil {
+		c.Bus.Cartridge.NoSave = on
+	}
+}
+
+func (c *Console) Loaded() bool {
+	return c.Bus != nil
+}
+
+func (c *Console) Reset() {
+	if c.Bus != nil {
+		c.Bus.Reset()
+	}
+}
+
+// 运行到下一帧画面完成
+func (c *Console) StepFrame() error {
+	if c.Bus == nil {
+		return ErrNoRom
+	}
+	c.Bus.PpuStep()
+	return nil
+}
+
+// player 为 0 或 1 buttons 的位顺序见 ButtonA 等常量
+func (c *Console) SetButtons(player int, buttons uint8) {
+	if c.Bus == nil {
+		return
+	}
+	if player == 0 {
+		c.Bus.Input1.SetButtons(buttons)
+	} else {
+		c.Bus.Input2.SetButtons(buttons)
+	}
+}
+
+func (c *Console) Buttons(player int) uint8 {
+	if c.Bus == nil {
+		return 0
+	}
+	if player == 0 {
+		return c.Bus.Input1.GetButtons()
+	}
+	return c.Bus.Input2.GetButtons()
+}
+
+// 最近完成的一帧是否为延迟帧 即游戏没有读取 $4016/$4017
+func (c *Console) Lag() bool {
+	return c.Bus != nil && c.Bus.Lag
+}
+
+func (c *Console) LagCount() uint64 {
+	if c.Bus == nil {
+		return 0
+	}
+	return c.Bus.LagCount
+}
+
+// 最近完成的一帧画面 Width*Height*4 的 RGBA 数据 下一帧完成前不会改变
+func (c *Console) Framebuffer() []uint8 {
+	if c.Bus == nil {
+		return nil
+	}
+	return c.Bus.Buffer()
+}
+
+// 上次读取之后生成的音频采样 单声道 SampleRate 没有扩展音频的游戏返回空
+func (c *Console) ReadSamples() []float32 {
+	if c.Bus == nil {
+		return nil
+	}
+	return c.Bus.Audio.Read()
+}
+
+// 最近完成的一帧画面的调色盘索引 每个像素 1byte 范围 0-63
+func (c *Console) PaletteBuffer() []uint8 {
+	if c.Bus == nil {
+		return nil
+	}
+	return c.Bus.PPU.FrontIndex
+}
+
+// 无副作用地读取 cpu 地址空间 不会触发寄存器读取的副作用
+func (c *Console) ReadMemory(addr uint16) uint8 {
+	if c.Bus == nil {
+		return 0
+	}
+	return c.Bus.CPU.Read(addr, true)
+}
+
+// 即时存档 只能读取到同一个 rom 上
+func (c *Console) SaveState(w io.Writer) error {
+	if c.Bus == nil {
+		return ErrNoRom
+	}
+	return c.Bus.SaveState(w)
+}
+
+func (c *Console) LoadState(r io.Reader) error {
+	if c.Bus == nil {
+		return ErrNoRom
+	}
+	return c.Bus.LoadState(r)
+}
@@ -0,0 +1,630 @@
+package nes
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var TestRoms = []string{"roms/nes_test.nes", "roms/超级玛莉.nes", "roms/魂斗罗美版.nes"}
+
+const TestFrames = 60
+
+// 固定的输入序列 保证每次运行结果相同 可以在其他 goroutine 中调用
+func RunTestConsole(t *testing.T, path string, colors *[64]color.RGBA) [sha1.Size]byte {
+	console := NewConsole()
+	if err := console.LoadROM(path); err != nil {
+		t.Errorf("load %s err %v", path, err)
+		return [sha1.Size]byte{}
+	}
+	if colors != nil {
+		console.SetPalette(*colors)
+	}
+	for i := 0; i < TestFrames; i++ {
+		console.SetButtons(0, uint8(i/20%2)<<ButtonStart|uint8(i/7%2)<<ButtonRight)
+		if err := console.StepFrame(); err != nil {
+			t.Error(err)
+		}
+	}
+	return sha1.Sum(console.Framebuffer())
+}
+
+func SkipWithoutRoms(t *testing.T) {
+	for _, path := range TestRoms {
+		if _, err := os.Stat(path); err != nil {
+			t.Skipf("test rom %s not found", path)
+		}
+	}
+}
+
+func TestConsoleConcurrent(t *testing.T) {
+	SkipWithoutRoms(t)
+	want := make(map[string][sha1.Size]byte)
+	for _, path := range TestRoms {
+		want[path] = RunTestConsole(t, path, nil)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		for _, path := range TestRoms {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				if got := RunTestConsole(t, path, nil); got != want[path] {
+					t.Errorf("%s concurrent run got %x want %x", path, got, want[path])
+				}
+			}(path)
+		}
+	}
+	wg.Wait()
+}
+
+// 修改一个实例的调色盘不能影响其他实例
+func TestConsolePaletteIsolation(t *testing.T) {
+	SkipWithoutRoms(t)
+	path := TestRoms[2]
+	want := RunTestConsole(t, path, nil)
+	inverted := DefaultPalette
+	for i, c := range inverted {
+		inverted[i] = color.RGBA{R: ^c.R, G: ^c.G, B: ^c.B, A: 0xFF}
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RunTestConsole(t, path, &inverted)
+		}()
+		go func() {
+			defer wg.Done()
+			if got := RunTestConsole(t, path, nil); got != want {
+				t.Errorf("default palette run got %x want %x", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLoadROMErrors(t *testing.T) {
+	dir := t.TempDir()
+	badMagic := filepath.Join(dir, "bad.nes")
+	if err := os.WriteFile(badMagic, make([]byte, 32), 0644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.nes")
+	if err := os.WriteFile(truncated, []byte{'N', 'E', 'S', 0x1A, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	noPrg := filepath.Join(dir, "noprg.nes")
+	if err := os.WriteFile(noPrg, []byte{'N', 'E', 'S', 0x1A, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	unsupported := filepath.Join(dir, "unsupported.nes")
+	data := append([]byte{'N', 'E', 'S', 0x1A, 1, 1, 0xF0, 0xF0, 0, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 24*1024)...)
+	if err := os.WriteFile(unsupported, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	console := NewConsole()
+	if err := console.LoadROM(filepath.Join(dir, "missing.nes")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file got %v", err)
+	}
+	if err := console.LoadROM(badMagic); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("bad magic got %v", err)
+	}
+	if err := console.LoadROM(truncated); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated got %v", err)
+	}
+	if err := console.LoadROM(noPrg); !errors.Is(err, ErrTruncated) {
+		t.Errorf("empty prg got %v", err)
+	}
+	if err := console.LoadROM(unsupported, filepath.Join(dir, "missing.ips")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing patch got %v", err)
+	}
+	mapperErr := &ErrUnsupportedMapper{}
+	if err := console.LoadROM(unsupported); !errors.As(err, &mapperErr) || mapperErr.Mapper != 255 {
+		t.Errorf("unsupported mapper got %v", err)
+	}
+	if console.Loaded() {
+		t.Error("failed load should keep console empty")
+	}
+}
+
+// 编号不是十六进制的 PRG 块被跳过 不能覆盖 PRG0
+func TestUNIFChunk(t *testing.T) {
+	chunk := func(id string, data []byte) []byte {
+		head := make([]byte, 8)
+		copy(head, id)
+		binary.LittleEndian.PutUint32(head[4:], uint32(len(data)))
+		return append(head, data...)
+	}
+	data := append([]byte(UNIFMagic), make([]byte, UNIFHeadSize-len(UNIFMagic))...)
+	data = append(data, chunk("MAPR", []byte("NES-NROM-256\x00"))...)
+	data = append(data, chunk("PRG0", bytes.Repeat([]byte{1}, 0x8000))...)
+	data = append(data, chunk("PRGG", bytes.Repeat([]byte{2}, 0x8000))...)
+	cartridge, err := LoadUNIF("game.unf", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cartridge.PRG) != 0x8000 || cartridge.PRG[0] != 1 || len(cartridge.Warnings) != 1 {
+		t.Errorf("got prg %d %d warnings %v", len(cartridge.PRG), cartridge.PRG[0], cartridge.Warnings)
+	}
+}
+
+// 文件名中的 # 不能被当作压缩包路径 压缩包内的每个 rom 使用各自的存档
+func TestArchivePath(t *testing.T) {
+	dir := t.TempDir()
+	rom := append([]byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 24*1024)...)
+	plain := filepath.Join(dir, "Game #1.nes")
+	if err := os.WriteFile(plain, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cartridge, err := LoadCartridge(plain)
+	if err != nil {
+		t.Fatalf("load %s err %v", plain, err)
+	}
+	if cartridge.SavePath() != filepath.Join(dir, "Game #1.sav") {
+		t.Errorf("plain save path got %s", cartridge.SavePath())
+	}
+	buff := &bytes.Buffer{}
+	writer := zip.NewWriter(buff)
+	for _, name := range []string{"a.nes", "b.nes"} {
+		item, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = item.Write(rom); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "multi.zip")
+	if err := os.WriteFile(archive, buff.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saves := make(map[string]bool)
+	for _, name := range []string{"a.nes", "b.nes"} {
+		cartridge, err = LoadCartridge(archive + "#" + name)
+		if err != nil {
+			t.Fatalf("load %s err %v", name, err)
+		}
+		saves[cartridge.SavePath()] = true
+	}
+	if len(saves) != 2 {
+		t.Errorf("zip entries share save path %v", saves)
+	}
+}
+
+// 压缩包内的 rom 使用压缩包旁边与包内文件同名的补丁 压缩包按魔数识别
+func TestArchivePatch(t *testing.T) {
+	dir := t.TempDir()
+	rom := append([]byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 24*1024)...)
+	buff := &bytes.Buffer{}
+	writer := zip.NewWriter(buff)
+	item, err := writer.Create("a.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = item.Write(rom); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "pack.bin")
+	if err = os.WriteFile(archive, buff.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buff = &bytes.Buffer{}
+	gz := gzip.NewWriter(buff)
+	if _, err = gz.Write(rom); err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "b.nes.gz"), buff.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 将 PRG 的第一个字节改为 patch 值
+	for name, val := range map[string]byte{"a.ips": 1, "b.ips": 2} {
+		patch := append([]byte("PATCH\x00\x00\x10\x00\x01"), val)
+		if err = os.WriteFile(filepath.Join(dir, name), append(patch, IPSEnd...), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]byte{archive + "#a.nes": 1, filepath.Join(dir, "b.nes.gz"): 2} {
+		cartridge, err := LoadCartridge(path)
+		if err != nil {
+			t.Fatalf("load %s err %v", path, err)
+		}
+		if cartridge.PRG[0] != want {
+			t.Errorf("%s got prg %d want %d", path, cartridge.PRG[0], want)
+		}
+	}
+}
+
+// 补丁中过大的大小与偏移返回错误 不能分配过大的内存或越界
+func TestPatchInvalid(t *testing.T) {
+	footer := make([]byte, 12)
+	tests := []struct {
+		patch []byte
+		want  error
+	}{
+		{append([]byte("UPS1\x80\x7F\x7F\x7F\x7F\x7F\x00"), footer...), ErrPatchInvalid},
+		{append([]byte("UPS1\x80\x81\xFF\xFF\xFF\x07\x01\x00"), footer...), ErrPatchInvalid},
+		{append([]byte("BPS1\x80\x7F\x7F\x7F\x7F\x7F\x00"), footer...), ErrPatchInvalid},
+		{append([]byte("BPS1\x80\x80\x7F\x7F\x81"), footer...), ErrPatchTruncated},
+	}
+	for _, test := range tests {
+		if _, err := ApplyPatch(make([]byte, 16), test.patch); !errors.Is(err, test.want) {
+			t.Errorf("patch %q got %v want %v", test.patch, err, test.want)
+		}
+	}
+}
+
+// rom 同目录下的 nes20db.xml 修正错误的文件头
+func TestGameDB(t *testing.T) {
+	dir := t.TempDir()
+	rom := make([]byte, 24*1024)
+	rom[0] = 1
+	data := append([]byte{'N', 'E', 'S', 0x1A, 1, 1, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}, rom...)
+	path := filepath.Join(dir, "game.nes")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := fmt.Sprintf(`<nes20db><game>
+<!-- Test\Game (World).nes -->
+<rom size="24576" crc32="%08X"/>
+<pcb mapper="3" submapper="0" mirroring="V" battery="0"/>
+<console type="0" region="0"/>
+</game></nes20db>`, crc32.ChecksumIEEE(rom))
+	if err := os.WriteFile(filepath.Join(dir, NES20DBName), []byte(db), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cartridge, err := LoadCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cartridge.Mapper != 3 || cartridge.Mirror != MirrorVertical || cartridge.Name != "Game (World)" {
+		t.Errorf("got mapper %d mirror %d name %s", cartridge.Mapper, cartridge.Mirror, cartridge.Name)
+	}
+	if len(cartridge.Fixes) != 2 {
+		t.Errorf("got fixes %v", cartridge.Fixes)
+	}
+	// iNES 1.0 的 12-15 字节有垃圾时忽略 mapper 高 4 位
+	rom[0] = 2
+	data = append([]byte{'N', 'E', 'S', 0x1A, 1, 1, 0x10, 'D', 'i', 's', 'k', 'D', 'u', 'd', 'e', '!'}, rom...)
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cartridge, err = LoadCartridge(path); err != nil {
+		t.Fatal(err)
+	}
+	if cartridge.Mapper != 1 || len(cartridge.Warnings) != 1 {
+		t.Errorf("diskdude got mapper %d warnings %v", cartridge.Mapper, cartridge.Warnings)
+	}
+	// 内置表中 StarTropics 使用 MMC6
+	if info := GameDB[0x889129CB]; info == nil || info.Mapper != 4 || info.Submapper != 1 {
+		t.Errorf("got startropics %v", info)
+	}
+}
+
+// 读档后继续运行的结果必须与不读档时相同
+func TestSaveState(t *testing.T) {
+	SkipWithoutRoms(t)
+	for index, path := range TestRoms {
+		console := NewConsole()
+		if err := console.LoadROM(path); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < TestFrames; i++ {
+			console.StepFrame()
+		}
+		buff := &bytes.Buffer{}
+		if err := console.SaveState(buff); err != nil {
+			t.Fatalf("%s save err %v", path, err)
+		}
+		data := buff.Bytes()
+		older, err := console.Bus.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := func() [sha1.Size]byte {
+			for i := 0; i < TestFrames; i++ {
+				console.SetButtons(0, uint8(i/10%2)<<ButtonStart)
+				console.StepFrame()
+			}
+			return sha1.Sum(console.Framebuffer())
+		}
+		want := run()
+		if err := console.LoadState(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s load err %v", path, err)
+		}
+		if got := run(); got != want {
+			t.Errorf("%s after load got %x want %x", path, got, want)
+		}
+		other := NewConsole()
+		if err := other.LoadROM(TestRoms[(index+1)%len(TestRoms)]); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.LoadState(bytes.NewReader(data)); !errors.Is(err, ErrStateRom) {
+			t.Errorf("%s load on other rom got %v", path, err)
+		}
+		newer := append([]byte(nil), data...)
+		newer[len(StateMagic)] = StateVersion + 1
+		if err := console.LoadState(bytes.NewReader(newer)); !errors.Is(err, ErrStateVersion) {
+			t.Errorf("%s load newer version got %v", path, err)
+		}
+		// 读取失败时恢复之前的状态 最后读取的字段损坏 前面的字段已经覆盖
+		before, err := console.Bus.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := ParseSnapshot(older)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Fields["Cartridge.Mirror"] = []byte{0xFF}
+		if err = console.Bus.ApplyState(state); err == nil {
+			t.Errorf("%s apply broken state should fail", path)
+		}
+		if after, _ := console.Bus.Snapshot(); !bytes.Equal(after, before) {
+			t.Errorf("%s failed load changed the console", path)
+		}
+	}
+}
+
+func TestSaveSlot(t *testing.T) {
+	SkipWithoutRoms(t)
+	data, err := os.ReadFile(TestRoms[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "game.nes")
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	console := NewConsole()
+	if err = console.LoadROM(path); err != nil {
+		t.Fatal(err)
+	}
+	console.StepFrame()
+	if err = console.SaveSlot(3); err != nil {
+		t.Fatal(err)
+	}
+	slot, err := console.ReadSlot(3)
+	if err != nil || slot.Empty() || slot.Thumb == nil || slot.Thumb.Bounds().Dx() != ThumbWidth {
+		t.Errorf("read slot 3 got %+v err %v", slot, err)
+	}
+	if slot, err = console.ReadSlot(4); err != nil || !slot.Empty() {
+		t.Errorf("read empty slot 4 got %+v err %v", slot, err)
+	}
+	if err = console.LoadSlot(3); err != nil {
+		t.Error(err)
+	}
+}
+
+// 后退到的每一帧画面都必须与当时运行的画面相同
+func TestRewind(t *testing.T) {
+	SkipWithoutRoms(t)
+	console := NewConsole()
+	if err := console.LoadROM(TestRoms[2]); err != nil {
+		t.Fatal(err)
+	}
+	rewind := NewRewind(RewindInterval, RewindLimit)
+	hashes := make([][sha1.Size]byte, 0)
+	for i := 0; i < TestFrames; i++ {
+		console.SetButtons(0, uint8(i/20%2)<<ButtonStart|uint8(i/7%2)<<ButtonRight)
+		if err := rewind.Step(console); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, sha1.Sum(console.Framebuffer()))
+	}
+	for frame := TestFrames - 1; frame > 0; frame-- {
+		ok, err := rewind.Back(console)
+		if !ok || err != nil {
+			t.Fatalf("back to frame %d got %v %v", frame, ok, err)
+		}
+		if got := sha1.Sum(console.Framebuffer()); got != hashes[frame-1] {
+			t.Fatalf("back to frame %d got %x want %x", frame, got, hashes[frame-1])
+		}
+	}
+	if ok, _ := rewind.Back(console); ok {
+		t.Error("back before first snapshot should fail")
+	}
+}
+
+// 很大的 lagFrames 范围截断到录像长度 FDS 的校验和与 BIOS 无关
+func TestMovieHeader(t *testing.T) {
+	movie, err := ReadFM2(strings.NewReader("version 3\nlagFrames 1-2000000000\n|0|........|||\n|0|........|||\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movie.Lag) != 2 || movie.Lag[0] || !movie.Lag[1] {
+		t.Errorf("got lag %v", movie.Lag)
+	}
+	disk := [][]byte{{1, 2}, {3, 4}}
+	if RomChecksum(&Cartridge{PRG: []byte{1}, Disk: disk}) != RomChecksum(&Cartridge{PRG: []byte{2}, Disk: disk}) {
+		t.Error("fds checksum should not include bios")
+	}
+}
+
+// 录制的录像写出再读取后回放 结果必须与录制时相同
+func TestMovie(t *testing.T) {
+	SkipWithoutRoms(t)
+	console := NewConsole()
+	if err := console.LoadROM(TestRoms[2]); err != nil {
+		t.Fatal(err)
+	}
+	movie, err := NewMovie(console, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < TestFrames; i++ {
+		console.SetButtons(0, uint8(i/20%2)<<ButtonStart|uint8(i/7%2)<<ButtonRight)
+		command := uint8(0)
+		if i == TestFrames/2 {
+			command = MovieSoftReset
+		}
+		if err = movie.Record(console, i, command); err != nil {
+			t.Fatal(err)
+		}
+		console.StepFrame()
+		movie.SetLag(i, console.Lag())
+	}
+	want := sha1.Sum(console.Framebuffer())
+	lag := movie.Lag
+	buff := &bytes.Buffer{}
+	if err = movie.WriteFM2(buff); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buff.Bytes(), []byte("\n|0|....T...|........||\n")) {
+		t.Errorf("fm2 missing start frame:\n%s", buff.String())
+	}
+	movie, err = ReadFM2(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FormatFrameRanges(movie.Lag, TestFrames) != FormatFrameRanges(lag, TestFrames) || movie.LagCount() == 0 {
+		t.Errorf("movie lag got %v want %v", movie.Lag, lag)
+	}
+	if err = movie.Start(console); err != nil {
+		t.Fatal(err)
+	}
+	for i := range movie.Frames {
+		if err = movie.Apply(console, i); err != nil {
+			t.Fatal(err)
+		}
+		console.StepFrame()
+	}
+	if got := sha1.Sum(console.Framebuffer()); got != want {
+		t.Errorf("movie playback got %x want %x", got, want)
+	}
+	if console.LagCount() != uint64(movie.LagCount()) {
+		t.Errorf("playback lag count got %d want %d", console.LagCount(), movie.LagCount())
+	}
+	if err = movie.CheckRom(console); err != nil {
+		t.Errorf("same rom check got %v", err)
+	}
+	other := NewConsole()
+	if err = other.LoadROM(TestRoms[1]); err != nil {
+		t.Fatal(err)
+	}
+	checksumErr := &ErrMovieChecksum{}
+	if err = movie.CheckRom(other); !errors.As(err, &checksumErr) {
+		t.Errorf("other rom check got %v", err)
+	}
+}
+
+// 重新开机使用内存中的卡带 rom 文件被删除后仍然可以开机 结果与第一次开机相同
+func TestPower(t *testing.T) {
+	SkipWithoutRoms(t)
+	data, err := os.ReadFile(TestRoms[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "game.nes")
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := RunTestConsole(t, path, nil)
+	console := NewConsole()
+	if err = console.LoadROM(path); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < TestFrames; i++ {
+		console.SetButtons(0, uint8(i/5%2)<<ButtonStart)
+		console.StepFrame()
+	}
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err = console.Power(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < TestFrames; i++ {
+		console.SetButtons(0, uint8(i/20%2)<<ButtonStart|uint8(i/7%2)<<ButtonRight)
+		console.StepFrame()
+	}
+	if got := sha1.Sum(console.Framebuffer()); got != want {
+		t.Errorf("after power got %x want %x", got, want)
+	}
+}
+
+// 修改输入后绿区重新运行的结果必须与从头运行相同 读取书签后回到修改前的分支
+func TestTAS(t *testing.T) {
+	SkipWithoutRoms(t)
+	console := NewConsole()
+	if err := console.LoadROM(TestRoms[2]); err != nil {
+		t.Fatal(err)
+	}
+	movie, err := NewMovie(console, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tas, err := NewTAS(console, movie, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tas.Seek(console, TestFrames); err != nil {
+		t.Fatal(err)
+	}
+	if err = tas.SetBookmark(console, 1); err != nil {
+		t.Fatal(err)
+	}
+	before := sha1.Sum(console.Framebuffer())
+	for _, frame := range []int{TestFrames / 3, TestFrames / 2} {
+		if err = tas.Toggle(console, frame, 0, ButtonStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tas.Frame != TestFrames || tas.End != TestFrames || len(movie.Lag) != TestFrames {
+		t.Errorf("after toggle frame %d end %d lag %d", tas.Frame, tas.End, len(movie.Lag))
+	}
+	other := NewConsole()
+	if err = other.LoadROM(TestRoms[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err = movie.Start(other); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < TestFrames; i++ {
+		movie.Apply(other, i)
+		other.StepFrame()
+	}
+	if got, want := sha1.Sum(console.Framebuffer()), sha1.Sum(other.Framebuffer()); got != want {
+		t.Errorf("greenzone replay got %x want %x", got, want)
+	}
+	seeded, err := NewTAS(other, movie, TestFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seeded.Movie.LagCount() == 0 || !seeded.InGreenzone(TestFrames) || seeded.InGreenzone(TestFrames+1) {
+		t.Errorf("seeded lag count %d end %d", seeded.Movie.LagCount(), seeded.End)
+	}
+	seeded.Limit = seeded.Size * 3
+	if err = seeded.Seek(other, TestFrames*3); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := seeded.Greenzone[seeded.Frame]; !ok || seeded.Size > seeded.Limit {
+		t.Errorf("greenzone over limit size %d count %d", seeded.Size, len(seeded.Greenzone))
+	}
+	if ok, err := tas.LoadBookmark(console, 1); !ok || err != nil {
+		t.Fatalf("load bookmark got %v %v", ok, err)
+	}
+	if got := sha1.Sum(console.Framebuffer()); got != before {
+		t.Errorf("bookmark got %x want %x", got, before)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package nes
 
 import (
 	"fmt"
@@ -166,7 +166,11 @@ func (c *CPU) Read(addr uint16, debug bool) uint8 {
 		switch {
 		case addr < 0x2000:
 			return c.Bus.RAM[addr%0x0800]
-		case addr >= 0x6000:
+		case addr == 0x4016:
+			return c.Bus.Input1.Peek()
+		case addr == 0x4017:
+			return c.Bus.Input2.Peek()
+		case addr >= 0x4020: // mapper 在 debug 模式下不会确认 IRQ 等
 			return c.Bus.Mapper.Read(addr, debug)
 		default:
 			return 0
@@ -184,7 +188,7 @@ func (c *CPU) Read(addr uint16, debug bool) uint8 {
 		return c.Bus.Input1.Read()
 	case addr == 0x4017:
 		return c.Bus.Input2.Read()
-	case addr >= 0x6000:
+	case addr >= 0x4020: // $4020 之后都是卡带空间 部分 mapper 会在 $4020-$5FFF 放置寄存器
 		return c.Bus.Mapper.Read(addr, debug)
 	default:
 		//fmt.Printf("unsupport read addr %04X\n", addr)
@@ -203,7 +207,7 @@ func (c *CPU) Write(addr uint16, val uint8) {
 	case addr == 0x4016:
 		c.Bus.Input1.Write(val)
 		c.Bus.Input2.Write(val)
-	case addr >= 0x6000:
+	case addr >= 0x4020:
 		c.Bus.Mapper.Write(addr, val)
 	default:
 		//fmt.Printf("unsupport write addr %04X\n", addr)
@@ -349,7 +353,7 @@ func (c *CPU) TriggerNMI() {
 }
 
 func (c *CPU) TriggerIRQ() {
-	if c.I == 0 {
+	if c.I == 0 && c.IntType != IntNMI { // NMI 优先级更高，不能被 IRQ 覆盖
 		c.IntType = IntIRQ
 	}
 }
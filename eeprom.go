@@ -0,0 +1,166 @@
+package nes
+
+// EEPROM 传输阶段
+const (
+	EepromIdle       = 0
+	EepromRecvDevice = 1 // 接收设备地址 (仅 24C02)
+	EepromRecvAddr   = 2 // 接收数据地址
+	EepromRecvData   = 3 // 接收要写入的数据
+	EepromSendData   = 4 // 输出读取的数据
+	EepromAckOut     = 5 // EEPROM 应答
+	EepromAckIn      = 6 // 等待主机应答
+)
+
+// 24C01/24C02 串行 EEPROM 按 I2C 协议逐位模拟 SCL 为高时 SDA 下降为 START 上升为 STOP
+// SCL 上升沿采样主机数据 下降沿更新 EEPROM 输出
+type EEPROM struct {
+	Data     []byte
+	Is24C01  bool // 24C01 没有设备地址 第一个字节就是 7bit 地址 + 读写位
+	Scl      uint8
+	Sda      uint8
+	Out      uint8 // EEPROM 输出的 SDA 1 为释放
+	State    uint8
+	Next     uint8 // 应答后进入的阶段
+	BitCount int
+	Shift    uint8
+	Addr     uint8
+	Nack     bool // 主机不再读取
+	Dirty    bool // 有写入 STOP 时需要保存
+	OnSave   func(data []byte)
+}
+
+func NewEEPROM(size int, is24C01 bool) *EEPROM {
+	return &EEPROM{Data: make([]byte, size), Is24C01: is24C01, Scl: 1, Sda: 1, Out: 1}
+}
+
+func (e *EEPROM) Write(scl, sda uint8) {
+	switch {
+	case e.Scl == 1 && scl == 1 && e.Sda == 1 && sda == 0:
+		e.Start()
+	case e.Scl == 1 && scl == 1 && e.Sda == 0 && sda == 1:
+		e.Stop()
+	case e.Scl == 0 && scl == 1:
+		e.RiseEdge(sda)
+	case e.Scl == 1 && scl == 0:
+		e.FallEdge()
+	}
+	e.Scl = scl
+	e.Sda = sda
+}
+
+func (e *EEPROM) Read() uint8 {
+	return e.Out
+}
+
+func (e *EEPROM) Start() {
+	if e.Is24C01 {
+		e.State = EepromRecvAddr
+	} else {
+		e.State = EepromRecvDevice
+	}
+	e.BitCount = 0
+	e.Shift = 0
+	e.Out = 1
+}
+
+func (e *EEPROM) Stop() {
+	e.State = EepromIdle
+	e.Out = 1
+	if e.Dirty && e.OnSave != nil {
+		e.OnSave(e.Data)
+	}
+	e.Dirty = false
+}
+
+func (e *EEPROM) RiseEdge(sda uint8) {
+	switch e.State {
+	case EepromRecvDevice, EepromRecvAddr, EepromRecvData:
+		e.Shift = e.Shift<<1 | sda
+		e.BitCount++
+	case EepromAckIn:
+		e.Nack = sda == 1
+	}
+}
+
+func (e *EEPROM) FallEdge() {
+	switch e.State {
+	case EepromRecvDevice, EepromRecvAddr, EepromRecvData:
+		if e.BitCount < 8 {
+			return
+		}
+		if !e.RecvByte() {
+			e.State = EepromIdle
+			return
+		}
+		e.State = EepromAckOut
+		e.Out = 0
+	case EepromAckOut:
+		e.Out = 1
+		e.State = e.Next
+		e.BitCount = 0
+		e.Shift = 0
+		if e.State == EepromSendData {
+			e.SendByte()
+		}
+	case EepromSendData:
+		e.BitCount++
+		if e.BitCount < 8 {
+			e.Out = (e.Shift >> (7 - e.BitCount)) & 1
+			return
+		}
+		e.Addr = e.NextAddr(e.Addr)
+		e.State = EepromAckIn
+		e.Out = 1
+	case EepromAckIn:
+		if e.Nack {
+			e.State = EepromIdle
+			return
+		}
+		e.State = EepromSendData
+		e.SendByte()
+	}
+}
+
+// 处理接收到的完整字节 返回是否需要应答
+func (e *EEPROM) RecvByte() bool {
+	switch e.State {
+	case EepromRecvDevice:
+		if e.Shift&0xF0 != 0xA0 { // 不是 EEPROM 的设备地址
+			return false
+		}
+		if e.Shift&1 == 1 {
+			e.Next = EepromSendData
+		} else {
+			e.Next = EepromRecvAddr
+		}
+	case EepromRecvAddr:
+		if e.Is24C01 { // 7bit 地址 + 读写位
+			e.Addr = e.Shift >> 1
+			if e.Shift&1 == 1 {
+				e.Next = EepromSendData
+			} else {
+				e.Next = EepromRecvData
+			}
+		} else {
+			e.Addr = e.Shift
+			e.Next = EepromRecvData
+		}
+		e.Addr = uint8(int(e.Addr) % len(e.Data))
+	case EepromRecvData:
+		e.Data[e.Addr] = e.Shift
+		e.Addr = e.NextAddr(e.Addr)
+		e.Dirty = true
+		e.Next = EepromRecvData
+	}
+	return true
+}
+
+func (e *EEPROM) SendByte() {
+	e.BitCount = 0
+	e.Shift = e.Data[e.Addr]
+	e.Out = e.Shift >> 7
+}
+
+func (e *EEPROM) NextAddr(addr uint8) uint8 {
+	return uint8((int(addr) + 1) % len(e.Data))
+}
@@ -0,0 +1,40 @@
+package nes
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadMagic  = errors.New("bad magic")
+	ErrTruncated = errors.New("truncated")
+	ErrNoBios    = errors.New("not find disksys.rom")
+	ErrNSF       = errors.New("nsf is not supported")
+)
+
+// 不支持的 mapper UNIF 格式时 Board 为板子名称
+type ErrUnsupportedMapper struct {
+	Mapper    uint16
+	Submapper uint8
+	Board     string
+}
+
+func (e *ErrUnsupportedMapper) Error() string {
+	if e.Board != "" {
+		return fmt.Sprintf("unsupport unif board %s", e.Board)
+	}
+	if e.Submapper != 0 {
+		return fmt.Sprintf("unsupport mapper %d.%d", e.Mapper, e.Submapper)
+	}
+	return fmt.Sprintf("unsupport mapper %d", e.Mapper)
+}
+
+// 录像的 rom 校验和与当前 rom 不同 仍然可以回放 由调用方决定是否提示
+type ErrMovieChecksum struct {
+	Movie string
+	Rom   string
+}
+
+func (e *ErrMovieChecksum) Error() string {
+	return fmt.Sprintf("movie rom checksum %s mismatch %s", e.Movie, e.Rom)
+}
@@ -0,0 +1,714 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FDSMapper    = 20    // FDS 没有 mapper 号 使用 NES 2.0 中为其保留的 20
+	FDSSideSize  = 65500 // .fds 中每一面的大小
+	FDSHeadSize  = 16    // fwNES 头
+	FDSBiosSize  = 8 * 1024
+	FDSByteDelay = 150 // 磁盘每传输一个字节需要的 cpu 周期
+	FDSDiffMagic = "FDSD"
+)
+
+var (
+	FDSMagic     = []byte("FDS\x1A")
+	FDSDiskMagic = []byte("\x01*NINTENDO-HVC*")
+)
+
+func IsFDS(data []byte) bool {
+	return bytes.HasPrefix(data, FDSMagic) || bytes.HasPrefix(data, FDSDiskMagic)
+}
+
+// 读取 .fds 磁盘镜像 BIOS 在创建 mapper 时才加载 只查看信息时不需要 BIOS
+func LoadFDS(path string, data []byte) (*Cartridge, error) {
+	if bytes.HasPrefix(data, FDSMagic) { // 跳过 fwNES 头
+		data = data[FDSHeadSize:]
+	}
+	disk := make([][]byte, 0)
+	for len(data) >= FDSSideSize {
+		disk = append(disk, FDSAddGaps(data[:FDSSideSize]))
+		data = data[FDSSideSize:]
+	}
+	if len(disk) == 0 {
+		return nil, fmt.Errorf("%w: fds disk is empty", ErrTruncated)
+	}
+	return &Cartridge{CHR: make([]byte, 8*1024), ChrRam: 8 * 1024, Mapper: FDSMapper,
+		Mirror: MirrorHorizontal, Path: path, Disk: disk}, nil
+}
+
+// BIOS 需要用户提供 在 rom 同目录或当前目录下查找 disksys.rom
+func LoadFDSBios(path string) ([]byte, error) {
+	for _, item := range []string{filepath.Join(filepath.Dir(path), "disksys.rom"), "disksys.rom"} {
+		bios, err := os.ReadFile(item)
+		if err != nil {
+			continue
+		}
+		if len(bios) < FDSBiosSize {
+			return nil, fmt.Errorf("%w: fds bios %s", ErrTruncated, item)
+		}
+		return bios[len(bios)-FDSBiosSize:], nil // 部分 BIOS 带有 16byte 的头
+	}
+	return nil, ErrNoBios
+}
+
+// .fds 中只存储了块数据 真实磁盘中每个块之间有间隙，块开头有起始标记，结尾有 CRC
+// 这里转换为磁盘上真实的字节流 方便按字节时序模拟读写
+func FDSAddGaps(side []byte) []byte {
+	res := make([]byte, 0, FDSSideSize+FDSSideSize/4)
+	res = append(res, make([]byte, 28300/8)...) // 磁头起始的间隙
+	fileSize := 0
+	for pos := 0; pos < len(side); {
+		length := 0
+		switch side[pos] {
+		case 1: // 磁盘信息
+			length = 56
+		case 2: // 文件数目
+			length = 2
+		case 3: // 文件头 偏移 13 处为文件大小
+			length = 16
+			if pos+15 <= len(side) {
+				fileSize = int(binary.LittleEndian.Uint16(side[pos+13:]))
+			}
+		case 4: // 文件数据
+			length = 1 + fileSize
+		}
+		if length == 0 || pos+length > len(side) {
+			break
+		}
+		res = append(res, 0x80) // 块起始标记
+		res = append(res, side[pos:pos+length]...)
+		crc := FDSCrc(side[pos : pos+length])
+		res = append(res, uint8(crc), uint8(crc>>8))
+		res = append(res, make([]byte, 976/8)...) // 块之间的间隙
+		pos += length
+	}
+	for len(res) < FDSSideSize+28300/8 { // 剩余部分都是间隙
+		res = append(res, 0)
+	}
+	return res
+}
+
+// FDS 使用的 CRC-16 (反转的 CCITT 多项式 0x8408) 末尾补 2 个 0 字节
+func FDSCrc(data []byte) uint16 {
+	crc := uint16(0x8000)
+	buff := append(append([]byte(nil), data...), 0, 0)
+	for _, b := range buff {
+		for i := 0; i < 8; i++ {
+			carry := crc & 1
+			crc = crc>>1 | uint16((b>>i)&1)<<15
+			if carry != 0 {
+				crc ^= 0x8408
+			}
+		}
+	}
+	return crc
+}
+
+//=====================MapperFDS====================
+// FDS RAM 适配器 32k PRG-RAM，8k CHR-RAM，8k BIOS，定时器 IRQ，磁盘按字节传输并产生 IRQ，以及波表音频
+
+type MapperFDS struct {
+	*Cartridge
+	Bus  *Bus
+	RAM  []byte   // $6000-$DFFF
+	Orig [][]byte `state:"-"` // 原始磁盘数据 用于生成差异文件
+	// 定时器
+	TimerReload  uint16
+	TimerCounter uint16
+	TimerRepeat  bool
+	TimerEnable  bool
+	TimerIrq     bool
+	// $4023
+	DiskEnable  bool
+	SoundEnable bool
+	// $4025
+	MotorOn       bool
+	ResetTransfer bool
+	ReadMode      bool
+	CrcControl    bool
+	DiskReady     bool // bit6 开始读写
+	DiskIrqEnable bool
+	// 磁盘状态
+	Side        int  // 当前插入的面 -1 表示没有插入
+	NextSide    int  // 换面时弹出后要插入的面
+	EjectDelay  int  // 弹出后延迟插入
+	Position    int  // 磁头位置
+	Delay       int  // 下一个字节的延迟
+	Scanning    bool // 磁头正在扫描
+	EndOfHead   bool // 磁头到达末尾需要回到开头
+	GapEnded    bool // 读取时是否已经越过间隙
+	PrevCrc     bool
+	Crc         uint16
+	ReadData    uint8
+	WriteData   uint8
+	Transferred bool // $4030 bit1 字节传输完成
+	DiskIrq     bool
+	Dirty       bool // 磁盘有写入 停止写入时保存差异
+	Sound       *FDSAudio
+}
+
+// PRG 为空时加载 BIOS 找不到时返回 ErrNoBios
+func NewMapperFDS(bus *Bus) (Mapper, error) {
+	cartridge := bus.Cartridge
+	if len(cartridge.PRG) == 0 {
+		bios, err := LoadFDSBios(cartridge.Path)
+		if err != nil {
+			return nil, err
+		}
+		cartridge.PRG = bios
+	}
+	orig := make([][]byte, len(cartridge.Disk))
+	for i, side := range cartridge.Disk {
+		orig[i] = append([]byte(nil), side...)
+	}
+	m := &MapperFDS{Cartridge: cartridge, Bus: bus, RAM: make([]byte, 0x8000), Orig: orig, Side: 0, NextSide: -1,
+		EndOfHead: true, Sound: NewFDSAudio()}
+	m.LoadDiff()
+	return m, nil
+}
+
+// 磁盘写入保存到单独的差异文件 不修改原始镜像
+func (m *MapperFDS) DiffPath() string {
+	return strings.TrimSuffix(m.Path, filepath.Ext(m.Path)) + ".fdsdiff"
+}
+
+// 差异文件格式 FDSD 后跟若干记录 [面 1byte][偏移 4byte][长度 2byte][数据]
+func (m *MapperFDS) LoadDiff() {
+	if m.NoSave {
+		return
+	}
+	data, err := os.ReadFile(m.DiffPath())
+	if err != nil || !bytes.HasPrefix(data, []byte(FDSDiffMagic)) {
+		return
+	}
+	data = data[len(FDSDiffMagic):]
+	for len(data) >= 7 {
+		side := int(data[0])
+		offset := int(binary.LittleEndian.Uint32(data[1:]))
+		length := int(binary.LittleEndian.Uint16(data[5:]))
+		data = data[7:]
+		if length > len(data) || side >= len(m.Disk) || offset+length > len(m.Disk[side]) {
+			return
+		}
+		copy(m.Disk[side][offset:], data[:length])
+		data = data[length:]
+	}
+}
+
+func (m *MapperFDS) SaveDiff() {
+	if m.NoSave {
+		m.Dirty = false
+		return
+	}
+	buff := &bytes.Buffer{}
+	buff.WriteString(FDSDiffMagic)
+	for side := range m.Disk {
+		curr, orig := m.Disk[side], m.Orig[side]
+		for i := 0; i < len(curr); {
+			if curr[i] == orig[i] {
+				i++
+				continue
+			}
+			start := i // 找到连续不同的一段
+			for i < len(curr) && i-start < 0xFFFF && curr[i] != orig[i] {
+				i++
+			}
+			buff.WriteByte(uint8(side))
+			_ = binary.Write(buff, binary.LittleEndian, uint32(start))
+			_ = binary.Write(buff, binary.LittleEndian, uint16(i-start))
+			buff.Write(curr[start:i])
+		}
+	}
+	err := os.WriteFile(m.DiffPath(), buff.Bytes(), 0644)
+	if err != nil {
+		fmt.Printf("write fds diff err %v\n", err)
+	}
+	m.Dirty = false
+}
+
+// 弹出当前磁盘 一段时间后插入下一面 (BIOS 需要检测到磁盘弹出)
+func (m *MapperFDS) SwitchSide() {
+	if m.Side >= 0 {
+		m.NextSide = (m.Side + 1) % len(m.Disk)
+	} else {
+		m.NextSide = (m.NextSide + 1) % len(m.Disk)
+	}
+	m.Side = -1
+	m.EjectDelay = CPUFreq
+}
+
+func (m *MapperFDS) Read(addr uint16, debug bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		return m.CHR[addr]
+	case addr >= 0xE000:
+		return m.PRG[addr-0xE000]
+	case addr >= 0x6000:
+		return m.RAM[addr-0x6000]
+	case addr >= 0x4040 && addr < 0x4098:
+		if m.SoundEnable {
+			return m.Sound.Read(addr)
+		}
+		return 0
+	case addr == 0x4030:
+		if !m.DiskEnable {
+			return 0
+		}
+		res := uint8(0)
+		if m.TimerIrq {
+			res |= 0x01
+		}
+		if m.Transferred {
+			res |= 0x02
+		}
+		if m.Cartridge.Mirror == MirrorHorizontal {
+			res |= 0x08
+		}
+		if !debug { // 读取同时确认 IRQ
+			m.TimerIrq = false
+			m.DiskIrq = false
+			m.Transferred = false
+		}
+		return res
+	case addr == 0x4031:
+		if !debug {
+			m.DiskIrq = false
+			m.Transferred = false
+		}
+		return m.ReadData
+	case addr == 0x4032:
+		res := uint8(0x40)
+		if m.Side < 0 { // 没有插入磁盘 同时也没有就绪与写保护
+			res |= 0x07
+		} else if !m.Scanning {
+			res |= 0x02
+		}
+		return res
+	case addr == 0x4033: // 电池电量正常
+		return 0x80
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *MapperFDS) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.CHR[addr] = val
+	case addr >= 0xE000: // BIOS 只读
+	case addr >= 0x6000:
+		m.RAM[addr-0x6000] = val
+	case addr >= 0x4040 && addr < 0x4098:
+		if m.SoundEnable {
+			m.Sound.Write(addr, val)
+		}
+	case addr == 0x4020:
+		m.TimerReload = (m.TimerReload & 0xFF00) | uint16(val)
+	case addr == 0x4021:
+		m.TimerReload = (m.TimerReload & 0x00FF) | uint16(val)<<8
+	case addr == 0x4022:
+		if !m.DiskEnable {
+			return
+		}
+		m.TimerRepeat = val&1 != 0
+		m.TimerEnable = val&2 != 0
+		m.TimerIrq = false
+		if m.TimerEnable {
+			m.TimerCounter = m.TimerReload
+		}
+	case addr == 0x4023:
+		m.DiskEnable = val&1 != 0
+		m.SoundEnable = val&2 != 0
+		if !m.DiskEnable {
+			m.TimerEnable = false
+			m.TimerIrq = false
+		}
+	case addr == 0x4024:
+		if !m.DiskEnable {
+			return
+		}
+		m.WriteData = val
+		m.DiskIrq = false
+		m.Transferred = false
+	case addr == 0x4025:
+		if !m.DiskEnable {
+			return
+		}
+		m.WriteControl(val)
+	case addr < 0x4040: // $4026 外部接口 不处理
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+// $4025
+func (m *MapperFDS) WriteControl(val uint8) {
+	m.DiskIrq = false
+	m.MotorOn = val&0x01 != 0
+	m.ResetTransfer = val&0x02 != 0
+	readMode := val&0x04 != 0
+	if readMode && !m.ReadMode && m.Dirty { // 写入结束 保存差异
+		m.SaveDiff()
+	}
+	m.ReadMode = readMode
+	if val&0x08 != 0 {
+		m.Cartridge.Mirror = MirrorHorizontal
+	} else {
+		m.Cartridge.Mirror = MirrorVertical
+	}
+	m.CrcControl = val&0x10 != 0
+	m.DiskReady = val&0x40 != 0
+	m.DiskIrqEnable = val&0x80 != 0
+}
+
+func (m *MapperFDS) Step() {
+	m.StepTimer()
+	m.StepDisk()
+	if m.SoundEnable {
+		m.Sound.Step()
+	}
+	if m.TimerIrq || m.DiskIrq {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
+
+func (m *MapperFDS) Audio() float32 {
+	if !m.SoundEnable {
+		return 0
+	}
+	return m.Sound.Output()
+}
+
+func (m *MapperFDS) StepTimer() {
+	if !m.TimerEnable || !m.DiskEnable {
+		return
+	}
+	if m.TimerCounter == 0 {
+		m.TimerIrq = true
+		m.TimerCounter = m.TimerReload
+		if !m.TimerRepeat {
+			m.TimerEnable = false
+		}
+	} else {
+		m.TimerCounter--
+	}
+}
+
+func (m *MapperFDS) StepDisk() {
+	if m.EjectDelay > 0 { // 换面中
+		m.EjectDelay--
+		if m.EjectDelay == 0 {
+			m.Side = m.NextSide
+		}
+		return
+	}
+	if m.Side < 0 || !m.MotorOn {
+		m.EndOfHead = true
+		m.Scanning = false
+		return
+	}
+	if m.ResetTransfer && !m.Scanning {
+		return
+	}
+	if m.EndOfHead { // 磁头回到开头需要一段时间
+		m.Delay = 50000
+		m.EndOfHead = false
+		m.Position = 0
+		m.GapEnded = false
+		return
+	}
+	if m.Delay > 0 {
+		m.Delay--
+		return
+	}
+	m.Scanning = true
+	m.TransferByte()
+	m.Position++
+	if m.Position >= len(m.Disk[m.Side]) { // 到达末尾 停止并重新开始
+		m.MotorOn = false
+		m.EndOfHead = true
+		if m.Dirty {
+			m.SaveDiff()
+		}
+	} else {
+		m.Delay = FDSByteDelay
+	}
+}
+
+// 传输一个字节 读取时需要先越过间隙找到起始标记，写入时 CRC 控制位开启后写入 CRC
+func (m *MapperFDS) TransferByte() {
+	side := m.Disk[m.Side]
+	needIrq := m.DiskIrqEnable
+	if m.ReadMode {
+		data := side[m.Position]
+		if !m.DiskReady {
+			m.GapEnded = false
+			m.Crc = 0
+		} else if data != 0 && !m.GapEnded { // 越过间隙后的起始标记不需要传给 cpu
+			m.GapEnded = true
+			needIrq = false
+		}
+		if m.GapEnded {
+			m.Transferred = true
+			m.ReadData = data
+			if needIrq {
+				m.DiskIrq = true
+			}
+		}
+		return
+	}
+	data := uint8(0)
+	if !m.CrcControl {
+		m.Transferred = true
+		data = m.WriteData
+		if needIrq {
+			m.DiskIrq = true
+		}
+		m.Crc = m.UpdateCrc(m.Crc, data)
+	} else { // 写入 CRC 的两个字节
+		if !m.PrevCrc {
+			m.Crc = m.UpdateCrc(m.UpdateCrc(m.Crc, 0), 0)
+		}
+		data = uint8(m.Crc)
+		m.Crc >>= 8
+	}
+	if !m.DiskReady {
+		data = 0
+		m.Crc = 0x8000
+	}
+	m.PrevCrc = m.CrcControl
+	if side[m.Position] != data {
+		side[m.Position] = data
+		m.Dirty = true
+	}
+	m.GapEnded = false
+}
+
+func (m *MapperFDS) UpdateCrc(crc uint16, val uint8) uint16 {
+	for i := 0; i < 8; i++ {
+		carry := crc & 1
+		crc = crc>>1 | uint16((val>>i)&1)<<15
+		if carry != 0 {
+			crc ^= 0x8408
+		}
+	}
+	return crc
+}
+
+//=====================FDSAudio====================
+// 64 步 6bit 波表 + 64 步调制表 带音量包络与调制包络
+
+var (
+	FDSModLookup    = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+	FDSMasterVolume = [4]int{36, 24, 17, 14}
+)
+
+// 音量与调制共用的包络
+type FDSEnvelope struct {
+	Off      bool // 关闭包络直接使用 Speed 作为增益
+	Increase bool
+	Speed    uint8
+	Gain     uint8
+	Timer    int
+}
+
+func (e *FDSEnvelope) Write(val uint8, masterSpeed uint8) {
+	e.Off = val&0x80 != 0
+	e.Increase = val&0x40 != 0
+	e.Speed = val & 0x3F
+	if e.Off {
+		e.Gain = e.Speed
+	}
+	e.ResetTimer(masterSpeed)
+}
+
+func (e *FDSEnvelope) ResetTimer(masterSpeed uint8) {
+	e.Timer = 8 * (int(e.Speed) + 1) * int(masterSpeed)
+}
+
+func (e *FDSEnvelope) Tick(masterSpeed uint8) bool {
+	if e.Off || masterSpeed == 0 {
+		return false
+	}
+	e.Timer--
+	if e.Timer > 0 {
+		return false
+	}
+	e.ResetTimer(masterSpeed)
+	if e.Increase && e.Gain < 32 {
+		e.Gain++
+	} else if !e.Increase && e.Gain > 0 {
+		e.Gain--
+	}
+	return true
+}
+
+type FDSAudio struct {
+	WaveTable   [64]uint8
+	WaveWrite   bool // $4089 bit7 允许写入波表
+	WaveHalt    bool
+	EnvHalt     bool // $4083 bit6 暂停包络
+	WaveFreq    uint16
+	WaveAcc     uint32
+	WavePos     uint8
+	MasterVol   uint8
+	MasterSpeed uint8 // $408A
+	Volume      FDSEnvelope
+	// 调制
+	Mod        FDSEnvelope
+	ModTable   [64]uint8
+	ModPos     uint8
+	ModFreq    uint16
+	ModAcc     uint32
+	ModHalt    bool
+	ModCounter int8 // 7bit 有符号
+	ModOutput  int
+	LastOutput int
+}
+
+func NewFDSAudio() *FDSAudio {
+	return &FDSAudio{MasterSpeed: 0xE8}
+}
+
+func (a *FDSAudio) Read(addr uint16) uint8 {
+	switch {
+	case addr < 0x4080:
+		return a.WaveTable[addr-0x4040]
+	case addr == 0x4090:
+		return a.Volume.Gain
+	case addr == 0x4092:
+		return a.Mod.Gain
+	}
+	return 0
+}
+
+func (a *FDSAudio) Write(addr uint16, val uint8) {
+	switch addr {
+	case 0x4080:
+		a.Volume.Write(val, a.MasterSpeed)
+	case 0x4082:
+		a.WaveFreq = (a.WaveFreq & 0x0F00) | uint16(val)
+	case 0x4083:
+		a.WaveFreq = (a.WaveFreq & 0x00FF) | uint16(val&0x0F)<<8
+		a.EnvHalt = val&0x40 != 0
+		a.WaveHalt = val&0x80 != 0
+		if a.EnvHalt {
+			a.Volume.ResetTimer(a.MasterSpeed)
+			a.Mod.ResetTimer(a.MasterSpeed)
+		}
+	case 0x4084:
+		a.Mod.Write(val, a.MasterSpeed)
+	case 0x4085:
+		a.ModCounter = int8(val<<1) >> 1
+		a.UpdateModOutput()
+	case 0x4086:
+		a.ModFreq = (a.ModFreq & 0x0F00) | uint16(val)
+	case 0x4087:
+		a.ModFreq = (a.ModFreq & 0x00FF) | uint16(val&0x0F)<<8
+		a.ModHalt = val&0x80 != 0
+		if a.ModHalt {
+			a.ModAcc = 0
+		}
+	case 0x4088: // 调制暂停时才能写入 每次写入占 2 个位置
+		if a.ModHalt {
+			a.ModTable[a.ModPos&0x3F] = val & 7
+			a.ModTable[(a.ModPos+1)&0x3F] = val & 7
+			a.ModPos = (a.ModPos + 2) & 0x3F
+		}
+	case 0x4089:
+		a.WaveWrite = val&0x80 != 0
+		a.MasterVol = val & 3
+	case 0x408A:
+		a.MasterSpeed = val
+	default:
+		if addr < 0x4080 && a.WaveWrite {
+			a.WaveTable[addr-0x4040] = val & 0x3F
+		}
+	}
+}
+
+func (a *FDSAudio) Step() {
+	if !a.WaveHalt && !a.EnvHalt {
+		a.Volume.Tick(a.MasterSpeed)
+		if a.Mod.Tick(a.MasterSpeed) {
+			a.UpdateModOutput()
+		}
+	}
+	if !a.ModHalt && a.ModFreq > 0 {
+		a.ModAcc += uint32(a.ModFreq)
+		if a.ModAcc > 0xFFFF {
+			a.ModAcc -= 0x10000
+			offset := a.ModTable[a.ModPos]
+			if offset == 4 {
+				a.ModCounter = 0
+			} else {
+				a.ModCounter = int8(uint8(int(a.ModCounter)+FDSModLookup[offset])<<1) >> 1
+			}
+			a.ModPos = (a.ModPos + 1) & 0x3F
+			a.UpdateModOutput()
+		}
+	}
+	if a.WaveHalt {
+		a.WavePos = 0
+		a.WaveAcc = 0
+		a.UpdateOutput()
+		return
+	}
+	freq := int(a.WaveFreq) + a.ModOutput
+	if freq > 0 && !a.WaveWrite {
+		a.WaveAcc += uint32(freq)
+		if a.WaveAcc > 0xFFFF {
+			a.WaveAcc -= 0x10000
+			a.WavePos = (a.WavePos + 1) & 0x3F
+			a.UpdateOutput()
+		}
+	}
+}
+
+// 根据调制计数器与增益计算频率偏移
+func (a *FDSAudio) UpdateModOutput() {
+	temp := int(a.ModCounter) * int(a.Mod.Gain)
+	remainder := temp & 0x0F
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if a.ModCounter < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+	temp *= int(a.WaveFreq)
+	remainder = temp & 0x3F
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+	a.ModOutput = temp
+}
+
+func (a *FDSAudio) UpdateOutput() {
+	gain := int(a.Volume.Gain)
+	if gain > 32 {
+		gain = 32
+	}
+	a.LastOutput = int(a.WaveTable[a.WavePos]) * gain * FDSMasterVolume[a.MasterVol] / 1152
+}
+
+// 当前的输出电平 范围 0-1
+func (a *FDSAudio) Output() float32 {
+	return float32(a.LastOutput) / 63
+}
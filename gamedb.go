@@ -0,0 +1,243 @@
+package nes
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	RegionNTSC  = 0
+	RegionPAL   = 1
+	RegionMulti = 2
+	RegionDendy = 3
+
+	MirrorUnknown = 0xFF // 数据库中的四屏等镜像 保留文件头中的值
+	NES20DBName   = "nes20db.xml"
+)
+
+//go:embed gamedb.txt
+var GameDBText string
+
+// 游戏信息 按 PRG+CHR 的 crc32 索引
+type GameInfo struct {
+	Crc32     uint32
+	Sha1      string
+	Mapper    uint16
+	Submapper uint8
+	Mirror    uint8
+	Region    uint8
+	PrgRam    int
+	ChrRam    int
+	Name      string
+}
+
+// 内置的修正表只收录 iNES 1.0 文件头无法表示的游戏 用户提供的 nes20db.xml 中的条目优先
+var GameDB = MustParseGameDB(GameDBText)
+
+var (
+	NES20DBLock  = &sync.Mutex{}
+	NES20DBCache = make(map[string]map[uint32]*GameInfo) // 按文件路径缓存 文件不存在时为 nil
+)
+
+func MustParseGameDB(text string) map[uint32]*GameInfo {
+	res, err := ParseGameDB(text)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func ParseGameDB(text string) (map[uint32]*GameInfo, error) {
+	res := make(map[uint32]*GameInfo)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items := strings.Fields(line)
+		if len(items) < 9 {
+			return nil, fmt.Errorf("bad gamedb line %s", line)
+		}
+		nums := make([]uint64, 0, 7)
+		for i, item := range items[:8] {
+			if i == 1 { // sha1
+				continue
+			}
+			base := 10
+			if i == 0 {
+				base = 16
+			}
+			num, err := strconv.ParseUint(item, base, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad gamedb line %s: %w", line, err)
+			}
+			nums = append(nums, num)
+		}
+		res[uint32(nums[0])] = &GameInfo{Crc32: uint32(nums[0]), Sha1: strings.ToUpper(items[1]),
+			Mapper: uint16(nums[1]), Submapper: uint8(nums[2]), Mirror: uint8(nums[3]), Region: uint8(nums[4]),
+			PrgRam: int(nums[5]), ChrRam: int(nums[6]), Name: strings.Join(items[8:], " ")}
+	}
+	return res, nil
+}
+
+// nes20db.xml 中的一个游戏 注释为原始文件名 rom 为 PRG+CHR 的校验值
+type NES20Game struct {
+	Comment string       `xml:",comment"`
+	Rom     NES20Rom     `xml:"rom"`
+	Pcb     NES20Pcb     `xml:"pcb"`
+	Console NES20Console `xml:"console"`
+	PrgRam  []NES20Size  `xml:"prgram"`
+	PrgNv   []NES20Size  `xml:"prgnvram"`
+	ChrRam  []NES20Size  `xml:"chrram"`
+	ChrNv   []NES20Size  `xml:"chrnvram"`
+}
+
+type NES20Rom struct {
+	Crc32 string `xml:"crc32,attr"`
+	Sha1  string `xml:"sha1,attr"`
+}
+
+type NES20Pcb struct {
+	Mapper    uint16 `xml:"mapper,attr"`
+	Submapper uint8  `xml:"submapper,attr"`
+	Mirroring string `xml:"mirroring,attr"`
+}
+
+type NES20Console struct {
+	Region uint8 `xml:"region,attr"`
+}
+
+type NES20Size struct {
+	Size int `xml:"size,attr"`
+}
+
+type NES20DB struct {
+	Games []NES20Game `xml:"game"`
+}
+
+func ParseNES20DB(data []byte) (map[uint32]*GameInfo, error) {
+	db := &NES20DB{}
+	if err := xml.Unmarshal(data, db); err != nil {
+		return nil, err
+	}
+	res := make(map[uint32]*GameInfo)
+	for _, game := range db.Games {
+		crc, err := strconv.ParseUint(game.Rom.Crc32, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad nes20db crc32 %q: %w", game.Rom.Crc32, err)
+		}
+		info := &GameInfo{Crc32: uint32(crc), Sha1: strings.ToUpper(game.Rom.Sha1), Mapper: game.Pcb.Mapper,
+			Submapper: game.Pcb.Submapper, Mirror: MirrorUnknown, Region: game.Console.Region}
+		switch game.Pcb.Mirroring {
+		case "H":
+			info.Mirror = MirrorHorizontal
+		case "V":
+			info.Mirror = MirrorVertical
+		}
+		if info.Sha1 == "" {
+			info.Sha1 = "-"
+		}
+		for _, item := range append(game.PrgRam, game.PrgNv...) {
+			info.PrgRam += item.Size
+		}
+		for _, item := range append(game.ChrRam, game.ChrNv...) {
+			info.ChrRam += item.Size
+		}
+		name := filepath.Base(strings.ReplaceAll(strings.TrimSpace(game.Comment), `\`, "/"))
+		info.Name = strings.TrimSuffix(name, filepath.Ext(name))
+		res[info.Crc32] = info
+	}
+	return res, nil
+}
+
+// 与 FDS 的 BIOS 相同 在 rom 同目录或当前目录下查找 nes20db.xml 解析结果会被缓存
+func FindNES20DB(path string) (map[uint32]*GameInfo, error) {
+	NES20DBLock.Lock()
+	defer NES20DBLock.Unlock()
+	for _, item := range []string{filepath.Join(filepath.Dir(path), NES20DBName), NES20DBName} {
+		if db, ok := NES20DBCache[item]; ok {
+			if db != nil {
+				return db, nil
+			}
+			continue
+		}
+		data, err := os.ReadFile(item)
+		if err != nil {
+			NES20DBCache[item] = nil
+			continue
+		}
+		db, err := ParseNES20DB(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item, err)
+		}
+		NES20DBCache[item] = db
+		return db, nil
+	}
+	return nil, nil
+}
+
+// 先查 nes20db.xml 再查内置的修正表 先用 crc32 查找 数据库中有 sha1 时再用 sha1 确认
+func FindGameInfo(path string, data []byte) (*GameInfo, error) {
+	db, err := FindNES20DB(path)
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.ChecksumIEEE(data)
+	sum := ""
+	for _, table := range []map[uint32]*GameInfo{db, GameDB} {
+		info, ok := table[crc]
+		if !ok {
+			continue
+		}
+		if info.Sha1 != "-" {
+			if sum == "" {
+				res := sha1.Sum(data)
+				sum = strings.ToUpper(hex.EncodeToString(res[:]))
+			}
+			if sum != info.Sha1 {
+				continue
+			}
+		}
+		return info, nil
+	}
+	return nil, nil
+}
+
+// 使用数据库中的信息覆盖文件头中的信息 返回修正的内容
+// exact 为 false 时文件头没有给出 RAM 大小与制式 (iNES 1.0) 覆盖这些值不算修正
+func (c *Cartridge) ApplyGameInfo(info *GameInfo, exact bool) []string {
+	fixes := make([]string, 0)
+	if c.Mapper != info.Mapper || c.Submapper != info.Submapper {
+		fixes = append(fixes, fmt.Sprintf("mapper %d.%d -> %d.%d", c.Mapper, c.Submapper, info.Mapper, info.Submapper))
+		c.Mapper, c.Submapper = info.Mapper, info.Submapper
+	}
+	if info.Mirror != MirrorUnknown && c.Mirror != info.Mirror {
+		fixes = append(fixes, fmt.Sprintf("mirror %d -> %d", c.Mirror, info.Mirror))
+		c.Mirror = info.Mirror
+	}
+	if exact && c.Region != info.Region {
+		fixes = append(fixes, fmt.Sprintf("region %d -> %d", c.Region, info.Region))
+	}
+	c.Region = info.Region
+	if exact && c.PrgRam != info.PrgRam {
+		fixes = append(fixes, fmt.Sprintf("prg ram %d -> %d", c.PrgRam, info.PrgRam))
+	}
+	c.PrgRam = info.PrgRam
+	if exact && c.ChrRam != info.ChrRam {
+		fixes = append(fixes, fmt.Sprintf("chr ram %d -> %d", c.ChrRam, info.ChrRam))
+	}
+	c.ChrRam = info.ChrRam
+	c.Name = info.Name
+	return fixes
+}
@@ -0,0 +1,289 @@
+// Package headless 无界面运行模拟器 不依赖图形环境 用于 CI 与批量测试
+package headless
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nes"
+)
+
+var ButtonNames = map[string]uint8{
+	"a": nes.ButtonA, "b": nes.ButtonB, "select": nes.ButtonSelect, "start": nes.ButtonStart,
+	"up": nes.ButtonUp, "down": nes.ButtonDown, "left": nes.ButtonLeft, "right": nes.ButtonRight,
+}
+
+// 从 Frame 开始按住 Buttons 直到下一个事件
+type InputEvent struct {
+	Frame   int
+	Buttons [2]uint8
+}
+
+// 输入脚本 每行 <帧> <1p 按键> [<2p 按键>] 按键用 + 连接 例如 "120 start" "300 right+a"
+// "-" 表示松开所有按键 # 之后为注释
+func ParseInputScript(reader io.Reader) ([]InputEvent, error) {
+	res := make([]InputEvent, 0)
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if index := strings.Index(text, "#"); index >= 0 {
+			text = text[:index]
+		}
+		items := strings.Fields(text)
+		if len(items) == 0 {
+			continue
+		}
+		if len(items) > 3 {
+			return nil, fmt.Errorf("input script line %d: too many fields", line)
+		}
+		frame, err := strconv.Atoi(items[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("input script line %d: bad frame %s", line, items[0])
+		}
+		event := InputEvent{Frame: frame}
+		for i, item := range items[1:] {
+			event.Buttons[i], err = ParseButtons(item)
+			if err != nil {
+				return nil, fmt.Errorf("input script line %d: %w", line, err)
+			}
+		}
+		res = append(res, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Frame < res[j].Frame
+	})
+	return res, nil
+}
+
+func ParseButtons(value string) (uint8, error) {
+	if value == "-" {
+		return 0, nil
+	}
+	res := uint8(0)
+	for _, name := range strings.Split(strings.ToLower(value), "+") {
+		button, ok := ButtonNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown button %s", name)
+		}
+		res |= 1 << button
+	}
+	return res, nil
+}
+
+// 运行 frames 帧 每帧开始前按脚本设置按键 返回运行期间的延迟帧数
+func Run(console *nes.Console, frames int, events []InputEvent) (int, error) {
+	next, lag := 0, 0
+	for frame := 0; frame < frames; frame++ {
+		for next < len(events) && events[next].Frame <= frame {
+			console.SetButtons(0, events[next].Buttons[0])
+			console.SetButtons(1, events[next].Buttons[1])
+			next++
+		}
+		if err := console.StepFrame(); err != nil {
+			return 0, err
+		}
+		if console.Lag() {
+			lag++
+		}
+	}
+	return lag, nil
+}
+
+// 按录像运行 frames 帧 超出录像长度的帧松开所有按键
+// 返回从录像开始的延迟帧数 与录像的 lagCount 相同 从即时存档开始时不包含存档之前的延迟帧
+func RunMovie(console *nes.Console, frames int, movie *nes.Movie) (int, error) {
+	if err := movie.Start(console); err != nil {
+		return 0, err
+	}
+	lag := 0
+	for frame := 0; frame < frames; frame++ {
+		if frame < len(movie.Frames) {
+			if err := movie.Apply(console, frame); err != nil {
+				return 0, err
+			}
+		} else {
+			console.SetButtons(0, 0)
+			console.SetButtons(1, 0)
+		}
+		if err := console.StepFrame(); err != nil {
+			return 0, err
+		}
+		if console.Lag() {
+			lag++
+		}
+	}
+	return lag, nil
+}
+
+func LoadMovie(path string) (*nes.Movie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return nes.ReadFM2(file)
+}
+
+// 画面的 sha1 用于比较不同版本的运行结果
+func FrameHash(console *nes.Console) string {
+	sum := sha1.Sum(console.Framebuffer())
+	return hex.EncodeToString(sum[:])
+}
+
+func FrameImage(console *nes.Console) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, nes.Width, nes.Height))
+	copy(img.Pix, console.Framebuffer())
+	return img
+}
+
+func WritePNG(path string, console *nes.Console) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, FrameImage(console))
+}
+
+// 2k 内部 RAM path 为 - 时以十六进制输出到 writer
+func WriteRAM(path string, console *nes.Console, writer io.Writer) error {
+	if path != "-" {
+		return os.WriteFile(path, console.Bus.RAM, 0644)
+	}
+	_, err := io.WriteString(writer, hex.Dump(console.Bus.RAM))
+	return err
+}
+
+type Options struct {
+	Frames int    // 小于 0 时使用录像的长度
+	Script string // 输入脚本路径
+	Movie  string // FM2 录像路径 不能与输入脚本同时使用
+	Lag    bool   // 输出延迟帧数
+	PNG    string // 最后一帧画面的输出路径
+	RAM    string // RAM 的输出路径 - 为标准输出
+}
+
+// 加载 rom 运行并输出结果 画面哈希总是输出到 writer
+func Main(path string, patches []string, options Options, writer io.Writer) error {
+	console := nes.NewConsole()
+	if err := console.LoadROM(path, patches...); err != nil {
+		return fmt.Errorf("load %s err %w", path, err)
+	}
+	for _, warning := range console.Bus.Cartridge.Warnings { // 警告不能混入结果
+		fmt.Fprintf(os.Stderr, "warning: %v\n", warning)
+	}
+	if fixes := console.Bus.Cartridge.Fixes; len(fixes) > 0 {
+		fmt.Fprintf(os.Stderr, "gamedb fix: %s\n", strings.Join(fixes, ", "))
+	}
+	if options.Movie != "" {
+		if options.Script != "" {
+			return fmt.Errorf("--input and --movie can not be used together")
+		}
+		movie, err := LoadMovie(options.Movie)
+		if err != nil {
+			return err
+		}
+		if options.Frames < 0 {
+			options.Frames = len(movie.Frames)
+		}
+		if err = movie.CheckRom(console); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+		lag, err := RunMovie(console, options.Frames, movie)
+		if err != nil {
+			return err
+		}
+		return WriteResult(path, options, console, lag, writer)
+	}
+	if options.Frames < 0 {
+		options.Frames = 60
+	}
+	events := make([]InputEvent, 0)
+	if options.Script != "" {
+		file, err := os.Open(options.Script)
+		if err != nil {
+			return err
+		}
+		events, err = ParseInputScript(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	lag, err := Run(console, options.Frames, events)
+	if err != nil {
+		return err
+	}
+	return WriteResult(path, options, console, lag, writer)
+}
+
+// lag 为本次运行的延迟帧数 不使用 Console.LagCount 因为读取即时存档时会恢复存档中的计数
+func WriteResult(path string, options Options, console *nes.Console, lag int, writer io.Writer) error {
+	if options.Lag {
+		if _, err := fmt.Fprintf(writer, "lag %d\n", lag); err != nil {
+			return err
+		}
+	}
+	if options.PNG != "" {
+		if err := WritePNG(options.PNG, console); err != nil {
+			return err
+		}
+	}
+	if options.RAM != "" {
+		if err := WriteRAM(options.RAM, console, writer); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(writer, "%s %s\n", FrameHash(console), path)
+	return err
+}
+
+// flag 包遇到第一个非参数就会停止解析 这里允许参数与 rom 路径交替出现
+func ParseArgs(set *flag.FlagSet, args []string) ([]string, error) {
+	rest := make([]string, 0)
+	for {
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+		args = set.Args()
+		if len(args) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// headless [flags] <rom> [patch...]
+func Command(args []string, writer io.Writer) error {
+	set := flag.NewFlagSet("headless", flag.ContinueOnError)
+	options := Options{}
+	set.IntVar(&options.Frames, "frames", -1, "运行的帧数 默认为录像的长度或 60")
+	set.StringVar(&options.Script, "input", "", "输入脚本 每行 <帧> <1p 按键> [<2p 按键>]")
+	set.StringVar(&options.Movie, "movie", "", "回放的 FM2 录像")
+	set.BoolVar(&options.Lag, "lag", false, "输出延迟帧(没有读取手柄的帧)的数量")
+	set.StringVar(&options.PNG, "png", "", "最后一帧画面输出的 png 路径")
+	set.StringVar(&options.RAM, "ram", "", "2k RAM 输出路径 - 为十六进制输出到标准输出")
+	args, err := ParseArgs(set, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		set.Usage()
+		return fmt.Errorf("headless: missing rom path")
+	}
+	return Main(args[0], args[1:], options, writer)
+}
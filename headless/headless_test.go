@@ -0,0 +1,121 @@
+package headless
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nes"
+)
+
+// 与 nes 包的测试相同 rom 不存在时跳过
+const TestRom = "../roms/超级玛莉.nes"
+
+func TestParseInputScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []InputEvent
+		err    bool
+	}{
+		{"comment", "# 开始\n\n120 start # 按下开始\n", []InputEvent{{120, [2]uint8{1 << nes.ButtonStart, 0}}}, false},
+		{"release", "10 a\n20 -\n", []InputEvent{{10, [2]uint8{1 << nes.ButtonA, 0}}, {20, [2]uint8{0, 0}}}, false},
+		{"2p", "5 - right+B\n", []InputEvent{{5, [2]uint8{0, 1<<nes.ButtonRight | 1<<nes.ButtonB}}}, false},
+		{"sort", "30 a\n10 b\n20 -\n", []InputEvent{{10, [2]uint8{1 << nes.ButtonB, 0}},
+			{20, [2]uint8{0, 0}}, {30, [2]uint8{1 << nes.ButtonA, 0}}}, false},
+		{"bad frame", "x a\n", nil, true},
+		{"negative frame", "-1 a\n", nil, true},
+		{"unknown button", "10 a+turbo\n", nil, true},
+		{"too many fields", "10 a b c\n", nil, true},
+	}
+	for _, test := range tests {
+		events, err := ParseInputScript(strings.NewReader(test.script))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: want error got %v", test.name, events)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(events, test.want) {
+			t.Errorf("%s: got %v want %v", test.name, events, test.want)
+		}
+	}
+}
+
+func TestParseButtons(t *testing.T) {
+	tests := []struct {
+		value string
+		want  uint8
+		err   bool
+	}{
+		{"-", 0, false},
+		{"a", 1 << nes.ButtonA, false},
+		{"Up+LEFT+select", 1<<nes.ButtonUp | 1<<nes.ButtonLeft | 1<<nes.ButtonSelect, false},
+		{"a+", 0, true},
+		{"turbo", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseButtons(test.value)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("%s: got %d err %v want %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+// 参数与 rom 路径交替出现
+func TestParseArgs(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	frames := set.Int("frames", -1, "")
+	lag := set.Bool("lag", false, "")
+	rest, err := ParseArgs(set, []string{"-frames", "10", "game.nes", "-lag", "a.ips", "b.ips"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *frames != 10 || !*lag || !reflect.DeepEqual(rest, []string{"game.nes", "a.ips", "b.ips"}) {
+		t.Errorf("got frames %d lag %v rest %v", *frames, *lag, rest)
+	}
+	set = flag.NewFlagSet("test", flag.ContinueOnError)
+	set.SetOutput(&bytes.Buffer{})
+	if _, err = ParseArgs(set, []string{"game.nes", "-unknown"}); err == nil {
+		t.Error("unknown flag should return error")
+	}
+}
+
+// 相同的输入运行两次结果相同 输出延迟帧与画面哈希
+func TestRun(t *testing.T) {
+	if _, err := os.Stat(TestRom); err != nil {
+		t.Skipf("test rom %s not found", TestRom)
+	}
+	events, err := ParseInputScript(strings.NewReader("30 start\n40 -\n60 right\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		console := nes.NewConsole()
+		if err = console.LoadROM(TestRom); err != nil {
+			t.Fatal(err)
+		}
+		lag, err := Run(console, 90, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buff := &bytes.Buffer{}
+		if err = WriteResult(TestRom, Options{Lag: true}, console, lag, buff); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, buff.String())
+	}
+	if results[0] != results[1] {
+		t.Errorf("run results differ %q %q", results[0], results[1])
+	}
+	lines := strings.Split(strings.TrimSpace(results[0]), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "lag ") || !strings.HasSuffix(lines[1], " "+TestRom) {
+		t.Errorf("got result %q", results[0])
+	}
+}
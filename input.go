@@ -1,20 +1,29 @@
-package main
+package nes
 
-import "github.com/hajimehoshi/ebiten/v2"
+const (
+	ButtonA      = 0
+	ButtonB      = 1
+	ButtonSelect = 2
+	ButtonStart  = 3
+	ButtonUp     = 4
+	ButtonDown   = 5
+	ButtonLeft   = 6
+	ButtonRight  = 7
+)
 
 type Input struct {
 	Buttons []bool
-	Keys    []ebiten.Key
 	Index   uint8
 	Strobe  uint8
+	Polled  bool // 本帧是否读取过按键 用于判断延迟帧
 }
 
-// keys顺序 A B Select Start Up Down Left Right
-func NewInput(keys ...ebiten.Key) *Input {
-	return &Input{Buttons: make([]bool, 8), Keys: keys}
+func NewInput() *Input {
+	return &Input{Buttons: make([]bool, 8)}
 }
 
 func (c *Input) Read() uint8 {
+	c.Polled = true
 	value := uint8(0)
 	if c.Index < 8 && c.Buttons[c.Index] {
 		value = 1
@@ -26,6 +35,14 @@ func (c *Input) Read() uint8 {
 	return value
 }
 
+// 调试读取 没有副作用 不影响延迟帧判断
+func (c *Input) Peek() uint8 {
+	if c.Index < 8 && c.Buttons[c.Index] {
+		return 1
+	}
+	return 0
+}
+
 func (c *Input) Write(val uint8) {
 	c.Strobe = val
 	if c.Strobe&1 == 1 {
@@ -33,8 +50,19 @@ func (c *Input) Write(val uint8) {
 	}
 }
 
-func (c *Input) Step() {
-	for i, key := range c.Keys {
-		c.Buttons[i] = ebiten.IsKeyPressed(key)
+// buttons 每一位对应一个按键 顺序 A B Select Start Up Down Left Right
+func (c *Input) SetButtons(buttons uint8) {
+	for i := range c.Buttons {
+		c.Buttons[i] = buttons&(1<<i) != 0
+	}
+}
+
+func (c *Input) GetButtons() uint8 {
+	buttons := uint8(0)
+	for i, pressed := range c.Buttons {
+		if pressed {
+			buttons |= 1 << i
+		}
 	}
+	return buttons
 }
@@ -1,4 +1,4 @@
-package main
+package nes
 
 import (
 	"fmt"
@@ -11,17 +11,78 @@ const (
 	MirrorSingle1    = 3
 )
 
-func NewMapper(bus *Bus) Mapper {
+// 不支持的 mapper 返回 ErrUnsupportedMapper
+func NewMapper(bus *Bus) (Mapper, error) {
 	cartridge := bus.Cartridge
 	switch cartridge.Mapper {
 	case 0, 2:
-		return NewMapper2(cartridge)
+		return NewMapper2(cartridge), nil
 	case 3:
-		return NewMapper3(cartridge)
+		return NewMapper3(cartridge), nil
+	case 4:
+		return NewMapper4(bus), nil
 	case 7:
-		return NewMapper7(cartridge)
+		return NewMapper7(cartridge), nil
+	case 11:
+		return NewMapper11(cartridge), nil
+	case 16, 153, 159:
+		return NewMapper16(bus), nil
+	case 18:
+		return NewMapper18(bus), nil
+	case 19:
+		return NewMapper19(bus), nil
+	case FDSMapper:
+		return NewMapperFDS(bus)
+	case 32:
+		return NewMapper32(cartridge), nil
+	case 33, 48:
+		return NewMapper33(bus), nil
+	case 34:
+		return NewMapper34(cartridge), nil
+	case 65:
+		return NewMapper65(bus), nil
+	case 66:
+		return NewMapper66(cartridge), nil
+	case 69:
+		return NewMapper69(bus), nil
+	case 71:
+		return NewMapper71(cartridge), nil
+	case 76:
+		return NewMapper4Board(bus, BoardNamco76), nil
+	case 79:
+		return NewMapper79(cartridge), nil
+	case 80:
+		return NewMapper80(cartridge), nil
+	case 87:
+		return NewMapper87(cartridge), nil
+	case 88:
+		return NewMapper4Board(bus, BoardNamco88), nil
+	case 95:
+		return NewMapper4Board(bus, BoardNamco95), nil
+	case 118:
+		return NewMapper4Board(bus, BoardTxSROM), nil
+	case 119:
+		return NewMapper4Board(bus, BoardTQROM), nil
+	case 140:
+		return NewMapper140(cartridge), nil
+	case 154:
+		return NewMapper4Board(bus, BoardNamco154), nil
+	case 185:
+		return NewMapper185(cartridge), nil
+	case 206:
+		return NewMapper4Board(bus, BoardNamco108), nil
+	case 225:
+		return NewMapper225(cartridge), nil
+	case 226:
+		return NewMapper226(cartridge), nil
+	case 227:
+		return NewMapper227(cartridge), nil
+	case 228:
+		return NewMapper228(cartridge), nil
+	case 233:
+		return NewMapper233(bus), nil
 	default:
-		panic(fmt.Sprintf("unsupport mapper %d", cartridge.Mapper))
+		return nil, &ErrUnsupportedMapper{Mapper: cartridge.Mapper, Submapper: cartridge.Submapper}
 	}
 }
 
@@ -30,18 +91,58 @@ type Mapper interface {
 	Write(addr uint16, val uint8)
 }
 
+// 没有防冲突逻辑的板子写入 ROM 空间时，cpu 写入的值会与 ROM 在该地址输出的值相与
+// NES 2.0 的 submapper 1 表示没有总线冲突，2 表示有总线冲突，其他情况使用板子的默认行为
+func HasBusConflict(cartridge *Cartridge, def bool) bool {
+	switch cartridge.Submapper {
+	case 1:
+		return false
+	case 2:
+		return true
+	default:
+		return def
+	}
+}
+
+// 需要跟随 cpu 周期推进的 mapper 实现该接口 一般用于 IRQ 计数器与扩展音频
+type MapperStepper interface {
+	Step()
+}
+
+// 带扩展音频的 mapper 实现该接口 返回当前的输出电平 由 Bus 按 SampleRate 采样
+type MapperAudio interface {
+	Audio() float32
+}
+
+// 复位时需要处理的 mapper 实现该接口 (例如通过复位切换游戏的合卡)
+type MapperReset interface {
+	Reset()
+}
+
+// 需要按扫描线计数的 mapper 实现该接口 (例如 MMC3 通过 PPU A12 的上升沿计数)
+type MapperScanline interface {
+	Scanline()
+}
+
+// 需要自己控制 nametable 映射的 mapper 实现该接口 (例如把 nametable 映射到 CHR-ROM 上)
+type MapperNameTable interface {
+	ReadNameTable(addr uint16) uint8
+	WriteNameTable(addr uint16, val uint8)
+}
+
 //=====================Mapper2====================
 
 type Mapper2 struct {
 	*Cartridge
-	PrgBanks int
-	PrgBank1 int
-	PrgBank2 int
+	PrgBanks    int
+	PrgBank1    int
+	PrgBank2    int
+	BusConflict bool
 }
 
 func NewMapper2(cartridge *Cartridge) Mapper {
 	prgBanks := len(cartridge.PRG) / 0x4000
-	return &Mapper2{cartridge, prgBanks, 0, prgBanks - 1}
+	return &Mapper2{cartridge, prgBanks, 0, prgBanks - 1, HasBusConflict(cartridge, true)}
 }
 
 func (m *Mapper2) Read(addr uint16, _ bool) uint8 {
@@ -65,6 +166,9 @@ func (m *Mapper2) Write(addr uint16, val uint8) {
 	case addr < 0x2000:
 		m.CHR[addr] = val
 	case addr >= 0x8000:
+		if m.BusConflict {
+			val &= m.Read(addr, true)
+		}
 		m.PrgBank1 = int(val) % m.PrgBanks
 	default:
 		fmt.Printf("unsupport read addr %04X\n", addr)
@@ -75,14 +179,15 @@ func (m *Mapper2) Write(addr uint16, val uint8) {
 
 type Mapper3 struct {
 	*Cartridge
-	ChrBank  int
-	PrgBank1 int
-	PrgBank2 int
+	ChrBank     int
+	PrgBank1    int
+	PrgBank2    int
+	BusConflict bool
 }
 
 func NewMapper3(cartridge *Cartridge) Mapper {
 	prgBanks := len(cartridge.PRG) / 0x4000
-	return &Mapper3{cartridge, 0, 0, prgBanks - 1}
+	return &Mapper3{cartridge, 0, 0, prgBanks - 1, HasBusConflict(cartridge, true)}
 }
 
 func (m *Mapper3) Read(addr uint16, _ bool) uint8 {
@@ -108,6 +213,9 @@ func (m *Mapper3) Write(addr uint16, val uint8) {
 		index := m.ChrBank*0x2000 + int(addr)
 		m.CHR[index] = val
 	case addr >= 0x8000:
+		if m.BusConflict {
+			val &= m.Read(addr, true)
+		}
 		m.ChrBank = int(val & 3)
 	default:
 		fmt.Printf("unsupport write addr %04X\n", addr)
@@ -118,11 +226,13 @@ func (m *Mapper3) Write(addr uint16, val uint8) {
 
 type Mapper7 struct {
 	*Cartridge
-	PrgBank int
+	PrgBank     int
+	BusConflict bool
 }
 
+// AxROM 中 AMROM 存在总线冲突 ANROM 等板子可以避免，默认不处理
 func NewMapper7(cartridge *Cartridge) Mapper {
-	return &Mapper7{cartridge, 0}
+	return &Mapper7{cartridge, 0, HasBusConflict(cartridge, false)}
 }
 
 func (m *Mapper7) Read(addr uint16, _ bool) uint8 {
@@ -143,6 +253,9 @@ func (m *Mapper7) Write(addr uint16, val uint8) {
 	case addr < 0x2000:
 		m.CHR[addr] = val
 	case addr >= 0x8000:
+		if m.BusConflict {
+			val &= m.Read(addr, true)
+		}
 		m.PrgBank = int(val & 7)
 		switch val & 0x10 {
 		case 0x00:
@@ -154,3 +267,319 @@ func (m *Mapper7) Write(addr uint16, val uint8) {
 		fmt.Printf("unsupport write addr %04X\n", addr)
 	}
 }
+
+//========================Mapper66=======================
+// GxROM 32k PRG bank 与 8k CHR bank 一起切换 其他同类的分立逻辑板也复用它的读取逻辑
+
+type Mapper66 struct {
+	*Cartridge
+	PrgBanks    int // 32k 的 PRG bank 数目
+	ChrBanks    int // 8k 的 CHR bank 数目
+	PrgBank     int
+	ChrBank     int
+	BusConflict bool // 寄存器位于 ROM 空间的板子都存在总线冲突
+}
+
+func NewMapper66(cartridge *Cartridge) Mapper {
+	return NewMapper66Base(cartridge)
+}
+
+func NewMapper66Base(cartridge *Cartridge) *Mapper66 {
+	prgBanks := len(cartridge.PRG) / 0x8000
+	if prgBanks == 0 { // 只有 16k 时镜像
+		prgBanks = 1
+	}
+	return &Mapper66{cartridge, prgBanks, len(cartridge.CHR) / 0x2000, 0, 0, true}
+}
+
+func (m *Mapper66) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		index := (m.ChrBank%m.ChrBanks)*0x2000 + int(addr)
+		return m.CHR[index]
+	case addr >= 0x8000:
+		index := (m.PrgBank%m.PrgBanks)*0x8000 + int(addr-0x8000)
+		return m.PRG[index%len(m.PRG)]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper66) WriteChr(addr uint16, val uint8) {
+	index := (m.ChrBank%m.ChrBanks)*0x2000 + int(addr)
+	m.CHR[index] = val
+}
+
+func (m *Mapper66) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		if m.BusConflict {
+			val &= m.Read(addr, true)
+		}
+		m.PrgBank = int(val>>4) & 3
+		m.ChrBank = int(val & 3)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper11=======================
+// Color Dreams 低 2 位选择 PRG 高 4 位选择 CHR
+
+type Mapper11 struct {
+	*Mapper66
+}
+
+func NewMapper11(cartridge *Cartridge) Mapper {
+	return &Mapper11{NewMapper66Base(cartridge)}
+}
+
+func (m *Mapper11) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		if m.BusConflict {
+			val &= m.Read(addr, true)
+		}
+		m.PrgBank = int(val & 3)
+		m.ChrBank = int(val >> 4)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper140=======================
+// Jaleco JF-11/JF-14 寄存器位于 $6000-$7FFF
+
+type Mapper140 struct {
+	*Mapper66
+}
+
+func NewMapper140(cartridge *Cartridge) Mapper {
+	return &Mapper140{NewMapper66Base(cartridge)}
+}
+
+func (m *Mapper140) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x6000 && addr < 0x8000:
+		m.PrgBank = int(val>>4) & 3
+		m.ChrBank = int(val & 0x0F)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper79=======================
+// AVE NINA-03/NINA-06 寄存器位于 $4100-$5FFF 中 A8 为 1 的地址
+
+type Mapper79 struct {
+	*Mapper66
+}
+
+func NewMapper79(cartridge *Cartridge) Mapper {
+	return &Mapper79{NewMapper66Base(cartridge)}
+}
+
+func (m *Mapper79) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x4100 && addr < 0x6000:
+		if addr&0xE100 == 0x4100 {
+			m.PrgBank = int(val>>3) & 1
+			m.ChrBank = int(val & 7)
+		}
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper87=======================
+// Jaleco/Konami 等的 CNROM 变体 寄存器位于 $6000-$7FFF 且 CHR bank 的低 2 位是反的
+
+type Mapper87 struct {
+	*Mapper66
+}
+
+func NewMapper87(cartridge *Cartridge) Mapper {
+	return &Mapper87{NewMapper66Base(cartridge)}
+}
+
+func (m *Mapper87) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x6000 && addr < 0x8000:
+		m.ChrBank = int((val&1)<<1 | (val&2)>>1)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper34=======================
+// BNROM 与 NINA-001 共用 34 号 submapper 1 为 NINA-001，2 为 BNROM，iNES 格式按 CHR 大小区分
+
+type Mapper34 struct {
+	*Cartridge
+	Nina     bool // 是否为 NINA-001
+	PrgBanks int  // 32k 的 PRG bank 数目
+	ChrBanks int  // 4k 的 CHR bank 数目
+	PrgBank  int
+	ChrBank  [2]int // NINA-001 的 2 个 4k CHR bank
+	SRAM     []byte // NINA-001 的 8k RAM
+}
+
+func NewMapper34(cartridge *Cartridge) Mapper {
+	nina := cartridge.Submapper == 1 || (cartridge.Submapper == 0 && len(cartridge.CHR) > 0x2000)
+	prgBanks := len(cartridge.PRG) / 0x8000
+	if prgBanks == 0 { // 只有 16k 时镜像
+		prgBanks = 1
+	}
+	return &Mapper34{Cartridge: cartridge, Nina: nina, PrgBanks: prgBanks,
+		ChrBanks: len(cartridge.CHR) / 0x1000, ChrBank: [2]int{0, 1}, SRAM: make([]byte, 0x2000)}
+}
+
+func (m *Mapper34) ChrIndex(addr uint16) int {
+	bank := m.ChrBank[addr/0x1000] % m.ChrBanks
+	return bank*0x1000 + int(addr%0x1000)
+}
+
+func (m *Mapper34) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		return m.CHR[m.ChrIndex(addr)]
+	case addr >= 0x8000:
+		index := (m.PrgBank%m.PrgBanks)*0x8000 + int(addr-0x8000)
+		return m.PRG[index%len(m.PRG)]
+	case addr >= 0x6000 && m.Nina:
+		return m.SRAM[addr-0x6000]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper34) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.CHR[m.ChrIndex(addr)] = val
+	case addr >= 0x8000:
+		if !m.Nina { // BNROM 存在总线冲突
+			m.PrgBank = int(val & m.Read(addr, true))
+		}
+	case addr >= 0x6000 && m.Nina:
+		m.SRAM[addr-0x6000] = val // 寄存器与 RAM 重叠 写入也会落到 RAM 上
+		switch addr {
+		case 0x7FFD:
+			m.PrgBank = int(val & 1)
+		case 0x7FFE:
+			m.ChrBank[0] = int(val & 0x0F)
+		case 0x7FFF:
+			m.ChrBank[1] = int(val & 0x0F)
+		}
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper71=======================
+// Camerica/Codemasters 类似 UNROM 寄存器在 $C000-$FFFF，Fire Hawk 使用 $9000 控制单屏镜像
+
+type Mapper71 struct {
+	*Cartridge
+	PrgBanks int
+	PrgBank1 int
+	PrgBank2 int
+}
+
+func NewMapper71(cartridge *Cartridge) Mapper {
+	prgBanks := len(cartridge.PRG) / 0x4000
+	return &Mapper71{cartridge, prgBanks, 0, prgBanks - 1}
+}
+
+func (m *Mapper71) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		return m.CHR[addr]
+	case addr >= 0xC000:
+		index := m.PrgBank2*0x4000 + int(addr-0xC000)
+		return m.PRG[index]
+	case addr >= 0x8000:
+		index := m.PrgBank1*0x4000 + int(addr-0x8000)
+		return m.PRG[index]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper71) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.CHR[addr] = val
+	case addr >= 0xC000:
+		m.PrgBank1 = int(val&0x0F) % m.PrgBanks
+	case addr >= 0x9000 && addr < 0xA000:
+		if val&0x10 == 0 {
+			m.Cartridge.Mirror = MirrorSingle0
+		} else {
+			m.Cartridge.Mirror = MirrorSingle1
+		}
+	case addr >= 0x8000: // 其余地址没有寄存器
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//========================Mapper185=======================
+// CNROM 的拷贝保护变体 写入特定的值才能读取 CHR 否则读到的都是 $FF
+// submapper 4-7 分别对应低 2 位为 0-3 时开启，iNES 格式使用通用的判断
+
+type Mapper185 struct {
+	*Cartridge
+	ChrEnable bool
+}
+
+func NewMapper185(cartridge *Cartridge) Mapper {
+	return &Mapper185{cartridge, true}
+}
+
+func (m *Mapper185) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		if !m.ChrEnable {
+			return 0xFF
+		}
+		return m.CHR[addr]
+	case addr >= 0x8000:
+		index := int(addr-0x8000) % len(m.PRG)
+		return m.PRG[index]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper185) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		if m.ChrEnable {
+			m.CHR[addr] = val
+		}
+	case addr >= 0x8000:
+		val &= m.Read(addr, true) // 与 CNROM 相同存在总线冲突 submapper 另有含义不使用 HasBusConflict
+		if m.Submapper >= 4 {
+			m.ChrEnable = val&3 == m.Submapper-4
+		} else {
+			m.ChrEnable = val&0x0F != 0 && val != 0x13
+		}
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
@@ -0,0 +1,179 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Mapper16====================
+// Bandai FCG-1/2 与 LZ93D50 16k PRG bank，1k CHR bank，16bit cpu 周期 IRQ 计数器
+// 16 号 submapper 4 为 FCG (寄存器在 $6000) 5 为 LZ93D50 + 24C02 (寄存器在 $8000) 0 两处都响应
+// 153 号 CHR 寄存器的 bit0 选择 256k 的外部 PRG bank 带 8k 电池 RAM，159 号使用 24C01
+
+type Mapper16 struct {
+	*Cartridge
+	Bus        *Bus
+	PrgBanks   int    // 16k 的 PRG bank 数目
+	ChrBanks   int    // 1k 的 CHR bank 数目
+	ChrBank    [8]int // 8 个 1k 的 CHR bank
+	PrgBank    int    // $8000 的 16k PRG bank $C000 固定为最后一个
+	OuterBank  int    // 153 的外部 PRG bank
+	IrqEnable  bool
+	IrqCounter uint16
+	IrqLatch   uint16 // LZ93D50 写入的是锁存值 开启 IRQ 时才复制到计数器
+	IrqPending bool
+	Eeprom     *EEPROM
+	SRAM       []byte // 153 的 8k RAM
+	RamEnable  bool
+	RamDirty   bool // RAM 有写入 关闭 RAM 时保存
+}
+
+func NewMapper16(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	m := &Mapper16{Cartridge: cartridge, Bus: bus, PrgBanks: len(cartridge.PRG) / 0x4000,
+		ChrBanks: len(cartridge.CHR) / 0x0400}
+	switch {
+	case cartridge.Mapper == 153:
+		m.SRAM = make([]byte, 0x2000)
+		cartridge.LoadSave(m.SRAM)
+	case cartridge.Mapper == 159:
+		m.Eeprom = NewEEPROM(128, true)
+	case cartridge.Submapper != 4:
+		m.Eeprom = NewEEPROM(256, false)
+	}
+	if m.Eeprom != nil {
+		cartridge.LoadSave(m.Eeprom.Data)
+		m.Eeprom.OnSave = cartridge.WriteSave
+	}
+	return m
+}
+
+// 寄存器的位置 FCG 在 $6000-$7FFF LZ93D50 在 $8000-$FFFF
+func (m *Mapper16) IsRegister(addr uint16) bool {
+	if m.Mapper != 16 {
+		return addr >= 0x8000
+	}
+	switch m.Submapper {
+	case 4:
+		return addr >= 0x6000 && addr < 0x8000
+	case 5:
+		return addr >= 0x8000
+	}
+	return addr >= 0x6000
+}
+
+func (m *Mapper16) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		if m.Mapper == 153 { // 153 使用 8k CHR-RAM
+			return m.CHR[addr]
+		}
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xC000:
+		bank := (m.OuterBank*16 + 15) % m.PrgBanks
+		return m.PRG[bank*0x4000+int(addr-0xC000)]
+	case addr >= 0x8000:
+		bank := (m.OuterBank*16 + m.PrgBank) % m.PrgBanks
+		return m.PRG[bank*0x4000+int(addr-0x8000)]
+	case addr >= 0x6000:
+		if m.SRAM != nil {
+			if m.RamEnable {
+				return m.SRAM[addr-0x6000]
+			}
+			return 0
+		}
+		if m.Eeprom != nil { // bit4 为 EEPROM 的 SDA 输出
+			return m.Eeprom.Read() << 4
+		}
+		return 0
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper16) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		if m.Mapper == 153 {
+			m.CHR[addr] = val
+			return
+		}
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case m.IsRegister(addr):
+		m.WriteRegister(addr, val)
+	case addr >= 0x6000 && addr < 0x8000:
+		if m.SRAM != nil && m.RamEnable {
+			m.SRAM[addr-0x6000] = val
+			m.RamDirty = true
+		}
+	case addr >= 0x6000: // FCG 的 $8000-$FFFF 没有寄存器
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper16) WriteRegister(addr uint16, val uint8) {
+	lz := addr >= 0x8000 // 只有 LZ93D50 使用 IRQ 锁存值
+	switch reg := addr & 0x0F; {
+	case reg < 8:
+		m.ChrBank[reg] = int(val)
+		if m.Mapper == 153 && reg < 4 {
+			m.OuterBank = int(val & 1)
+		}
+	case reg == 0x08:
+		m.PrgBank = int(val & 0x0F)
+	case reg == 0x09:
+		switch val & 3 {
+		case 0:
+			m.Cartridge.Mirror = MirrorVertical
+		case 1:
+			m.Cartridge.Mirror = MirrorHorizontal
+		case 2:
+			m.Cartridge.Mirror = MirrorSingle0
+		case 3:
+			m.Cartridge.Mirror = MirrorSingle1
+		}
+	case reg == 0x0A: // 写入同时确认 IRQ
+		m.IrqEnable = val&1 != 0
+		m.IrqPending = false
+		if lz {
+			m.IrqCounter = m.IrqLatch
+		}
+	case reg == 0x0B:
+		if lz {
+			m.IrqLatch = (m.IrqLatch & 0xFF00) | uint16(val)
+		} else {
+			m.IrqCounter = (m.IrqCounter & 0xFF00) | uint16(val)
+		}
+	case reg == 0x0C:
+		if lz {
+			m.IrqLatch = (m.IrqLatch & 0x00FF) | uint16(val)<<8
+		} else {
+			m.IrqCounter = (m.IrqCounter & 0x00FF) | uint16(val)<<8
+		}
+	case reg == 0x0D:
+		if m.SRAM != nil { // 153 的 bit5 控制 RAM 开关 关闭时保存
+			m.RamEnable = val&0x20 != 0
+			if !m.RamEnable && m.RamDirty {
+				m.Cartridge.WriteSave(m.SRAM)
+				m.RamDirty = false
+			}
+		} else if m.Eeprom != nil { // bit5 SCL bit6 SDA
+			m.Eeprom.Write((val>>5)&1, (val>>6)&1)
+		}
+	}
+}
+
+func (m *Mapper16) Step() {
+	if m.IrqEnable {
+		if m.IrqCounter == 0 {
+			m.IrqPending = true
+		}
+		m.IrqCounter--
+	}
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
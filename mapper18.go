@@ -0,0 +1,138 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Mapper18====================
+// Jaleco SS88006 bank 寄存器按 4bit 分两次写入，IRQ 计数器可以配置为 4/8/12/16bit
+
+type Mapper18 struct {
+	*Cartridge
+	Bus        *Bus
+	PrgBanks   int    // 8k 的 PRG bank 数目
+	ChrBanks   int    // 1k 的 CHR bank 数目
+	PrgBank    [3]int // $8000 $A000 $C000 $E000 固定为最后一个
+	ChrBank    [8]int
+	SRAM       []byte
+	RamEnable  bool
+	RamWrite   bool
+	IrqLatch   uint16
+	IrqCounter uint16
+	IrqMask    uint16 // 计数器参与递减的位
+	IrqEnable  bool
+	IrqPending bool
+}
+
+func NewMapper18(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	return &Mapper18{Cartridge: cartridge, Bus: bus, PrgBanks: len(cartridge.PRG) / 0x2000,
+		ChrBanks: len(cartridge.CHR) / 0x0400, SRAM: make([]byte, 0x2000), IrqMask: 0xFFFF}
+}
+
+// 按 4bit 写入 high 为真时写入高 4 位
+func SetNibble(reg int, val uint8, high bool) int {
+	if high {
+		return (reg & 0x0F) | int(val&0x0F)<<4
+	}
+	return (reg & 0xF0) | int(val&0x0F)
+}
+
+func (m *Mapper18) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xE000:
+		return m.PRG[(m.PrgBanks-1)*0x2000+int(addr-0xE000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x6000:
+		if m.RamEnable {
+			return m.SRAM[addr-0x6000]
+		}
+		return 0
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper18) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0x8000:
+		m.WriteRegister(addr, val)
+	case addr >= 0x6000:
+		if m.RamEnable && m.RamWrite {
+			m.SRAM[addr-0x6000] = val
+		}
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper18) WriteRegister(addr uint16, val uint8) {
+	reg := addr & 0xF003
+	high := reg&1 == 1
+	switch {
+	case reg < 0x9000: // $8000-$8003 PRG0 PRG1
+		i := (reg & 2) >> 1
+		m.PrgBank[i] = SetNibble(m.PrgBank[i], val, high)
+	case reg < 0x9002:
+		m.PrgBank[2] = SetNibble(m.PrgBank[2], val, high)
+	case reg == 0x9002:
+		m.RamEnable = val&1 != 0
+		m.RamWrite = val&2 != 0
+	case reg < 0xA000: // $9003 用于扩展音频 没有实现
+	case reg < 0xE000: // $A000-$D003 每个地址 2 个 CHR bank
+		i := ((reg-0xA000)>>12)*2 + (reg&2)>>1
+		m.ChrBank[i] = SetNibble(m.ChrBank[i], val, high)
+	case reg < 0xF000: // $E000-$E003 锁存值从低到高 4 个 4bit
+		shift := (reg & 3) * 4
+		m.IrqLatch = (m.IrqLatch &^ (0x0F << shift)) | uint16(val&0x0F)<<shift
+	case reg == 0xF000: // 重载同时确认 IRQ
+		m.IrqCounter = m.IrqLatch
+		m.IrqPending = false
+	case reg == 0xF001:
+		m.IrqEnable = val&1 != 0
+		m.IrqPending = false
+		switch {
+		case val&0x08 != 0:
+			m.IrqMask = 0x000F
+		case val&0x04 != 0:
+			m.IrqMask = 0x00FF
+		case val&0x02 != 0:
+			m.IrqMask = 0x0FFF
+		default:
+			m.IrqMask = 0xFFFF
+		}
+	case reg == 0xF002:
+		switch val & 3 {
+		case 0:
+			m.Cartridge.Mirror = MirrorHorizontal
+		case 1:
+			m.Cartridge.Mirror = MirrorVertical
+		case 2:
+			m.Cartridge.Mirror = MirrorSingle0
+		case 3:
+			m.Cartridge.Mirror = MirrorSingle1
+		}
+	}
+}
+
+func (m *Mapper18) Step() {
+	if m.IrqEnable { // 只有 mask 内的位参与递减 溢出时触发
+		count := m.IrqCounter & m.IrqMask
+		if count == 0 {
+			m.IrqPending = true
+		}
+		m.IrqCounter = (m.IrqCounter &^ m.IrqMask) | ((count - 1) & m.IrqMask)
+	}
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
@@ -0,0 +1,255 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Mapper19====================
+// Namco 163  8k PRG bank，1k CHR bank (可以映射 nametable)，15bit IRQ 计数器，128byte 内部 RAM 与最多 8 通道波表音频
+
+type Mapper19 struct {
+	*Cartridge
+	Bus          *Bus
+	PrgBanks     int    // 8k 的 PRG bank 数目
+	ChrBanks     int    // 1k 的 CHR bank 数目
+	PrgBank      [3]int // $8000 $A000 $C000 $E000 固定为最后一个
+	ChrBank      [8]int // 8 个 1k 的 CHR bank
+	NtBank       [4]int // 4 个 nametable 对应的 bank 大于等于 $E0 时使用 CIRAM
+	ChrRamLo     bool   // $0000-$0FFF 的 $E0-$FF bank 是否映射到 CIRAM
+	ChrRamHi     bool   // $1000-$1FFF 的 $E0-$FF bank 是否映射到 CIRAM
+	SRAM         []byte // $6000 的 8k RAM
+	WriteProtect uint8  // $F800 写保护 高 4 位为 4 时低 4 位每位保护 2k
+	IrqValue     uint16 // 15bit 计数器 每个 cpu 周期递增一次
+	IrqEnable    bool
+	IrqPending   bool
+	Sound        *Namco163
+}
+
+func NewMapper19(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	m := &Mapper19{Cartridge: cartridge, Bus: bus, PrgBanks: len(cartridge.PRG) / 0x2000,
+		ChrBanks: len(cartridge.CHR) / 0x0400, SRAM: make([]byte, 0x2000), Sound: NewNamco163()}
+	return m
+}
+
+// 获取 1k CHR bank 对应的数据 ciRam 为真时 $E0-$FF 的 bank 对应 CIRAM
+func (m *Mapper19) ChrAddr(bank int, ciRam bool) (*[2 * 1024]uint8, int) {
+	if ciRam && bank >= 0xE0 {
+		return &m.Bus.PPU.NameTable, (bank & 1) * 0x0400
+	}
+	return nil, (bank % m.ChrBanks) * 0x0400
+}
+
+func (m *Mapper19) Read(addr uint16, debug bool) uint8 {
+	switch {
+	case addr < 0x1000:
+		table, index := m.ChrAddr(m.ChrBank[addr/0x0400], m.ChrRamLo)
+		return m.ReadChr(table, index+int(addr%0x0400))
+	case addr < 0x2000:
+		table, index := m.ChrAddr(m.ChrBank[addr/0x0400], m.ChrRamHi)
+		return m.ReadChr(table, index+int(addr%0x0400))
+	case addr >= 0xE000:
+		return m.PRG[(m.PrgBanks-1)*0x2000+int(addr-0xE000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x6000:
+		return m.SRAM[addr-0x6000]
+	case addr >= 0x5800:
+		res := uint8(m.IrqValue >> 8)
+		if m.IrqEnable {
+			res |= 0x80
+		}
+		return res
+	case addr >= 0x5000:
+		return uint8(m.IrqValue)
+	case addr >= 0x4800:
+		return m.Sound.ReadData(debug)
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper19) ReadChr(table *[2 * 1024]uint8, index int) uint8 {
+	if table != nil {
+		return table[index]
+	}
+	return m.CHR[index]
+}
+
+func (m *Mapper19) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x1000:
+		table, index := m.ChrAddr(m.ChrBank[addr/0x0400], m.ChrRamLo)
+		m.WriteChr(table, index+int(addr%0x0400), val)
+	case addr < 0x2000:
+		table, index := m.ChrAddr(m.ChrBank[addr/0x0400], m.ChrRamHi)
+		m.WriteChr(table, index+int(addr%0x0400), val)
+	case addr >= 0xF800:
+		m.WriteProtect = val
+		m.Sound.WriteAddr(val)
+	case addr >= 0xF000:
+		m.PrgBank[2] = int(val & 0x3F)
+	case addr >= 0xE800:
+		m.PrgBank[1] = int(val & 0x3F)
+		m.ChrRamLo = val&0x40 == 0
+		m.ChrRamHi = val&0x80 == 0
+	case addr >= 0xE000:
+		m.PrgBank[0] = int(val & 0x3F)
+		m.Sound.Disable = val&0x40 != 0
+	case addr >= 0xC000:
+		m.NtBank[(addr-0xC000)/0x0800] = int(val)
+	case addr >= 0x8000:
+		m.ChrBank[(addr-0x8000)/0x0800] = int(val)
+	case addr >= 0x6000:
+		// 高 4 位为 4 时才允许写入 低 4 位每位对应 2k 的写保护
+		if m.WriteProtect&0xF0 == 0x40 && (m.WriteProtect>>((addr-0x6000)/0x0800))&1 == 0 {
+			m.SRAM[addr-0x6000] = val
+		}
+	case addr >= 0x5800: // 写入计数器同时确认 IRQ
+		m.IrqValue = (m.IrqValue & 0x00FF) | uint16(val&0x7F)<<8
+		m.IrqEnable = val&0x80 != 0
+		m.IrqPending = false
+	case addr >= 0x5000:
+		m.IrqValue = (m.IrqValue & 0x7F00) | uint16(val)
+		m.IrqPending = false
+	case addr >= 0x4800:
+		m.Sound.WriteData(val)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper19) WriteChr(table *[2 * 1024]uint8, index int, val uint8) {
+	if table != nil {
+		table[index] = val
+	} else {
+		m.CHR[index] = val
+	}
+}
+
+func (m *Mapper19) ReadNameTable(addr uint16) uint8 {
+	table, index := m.ChrAddr(m.NtBank[(addr-0x2000)/0x0400%4], true)
+	return m.ReadChr(table, index+int(addr%0x0400))
+}
+
+func (m *Mapper19) WriteNameTable(addr uint16, val uint8) {
+	table, index := m.ChrAddr(m.NtBank[(addr-0x2000)/0x0400%4], true)
+	m.WriteChr(table, index+int(addr%0x0400), val)
+}
+
+func (m *Mapper19) Step() {
+	if m.IrqEnable && m.IrqValue < 0x7FFF { // 计数到 $7FFF 停止并触发 IRQ
+		m.IrqValue++
+		if m.IrqValue == 0x7FFF {
+			m.IrqPending = true
+		}
+	}
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+	m.Sound.Step()
+}
+
+func (m *Mapper19) Audio() float32 {
+	return m.Sound.Output()
+}
+
+//=====================Namco163====================
+// 128byte 内部 RAM $40-$7F 为通道寄存器，其余为 4bit 的波表采样 最多 8 个通道分时输出
+
+type Namco163 struct {
+	RAM      [128]uint8
+	Addr     uint8 // 内部 RAM 地址
+	AutoIncr bool  // 读写后地址是否自增
+	Disable  bool  // $E000 bit6 关闭音频
+	Divider  int   // 每 15 个 cpu 周期更新一个通道
+	Channel  int   // 当前更新的通道 从 7 递减
+	Outputs  [8]float32
+}
+
+func NewNamco163() *Namco163 {
+	return &Namco163{Channel: 7}
+}
+
+// $F800
+func (n *Namco163) WriteAddr(val uint8) {
+	n.Addr = val & 0x7F
+	n.AutoIncr = val&0x80 != 0
+}
+
+// $4800
+func (n *Namco163) ReadData(debug bool) uint8 {
+	res := n.RAM[n.Addr]
+	if n.AutoIncr && !debug {
+		n.Addr = (n.Addr + 1) & 0x7F
+	}
+	return res
+}
+
+// $4800
+func (n *Namco163) WriteData(val uint8) {
+	n.RAM[n.Addr] = val
+	if n.AutoIncr {
+		n.Addr = (n.Addr + 1) & 0x7F
+	}
+}
+
+// 启用的通道数 $7F 的 bit4-6 + 1
+func (n *Namco163) ChannelNum() int {
+	return int((n.RAM[0x7F]>>4)&7) + 1
+}
+
+// 读取波表中的 4bit 采样 偶数地址在低 4 位
+func (n *Namco163) Sample(addr uint8) uint8 {
+	val := n.RAM[addr>>1]
+	if addr&1 == 1 {
+		return val >> 4
+	}
+	return val & 0x0F
+}
+
+func (n *Namco163) Step() {
+	if n.Disable {
+		return
+	}
+	n.Divider++
+	if n.Divider < 15 {
+		return
+	}
+	n.Divider = 0
+	n.UpdateChannel(n.Channel)
+	n.Channel--
+	if n.Channel < 8-n.ChannelNum() {
+		n.Channel = 7
+	}
+}
+
+// 通道寄存器 8byte: 频率 18bit，相位 24bit，波形长度，波形起始地址，音量
+func (n *Namco163) UpdateChannel(ch int) {
+	base := 0x40 + ch*8
+	reg := n.RAM[base : base+8]
+	freq := uint32(reg[0]) | uint32(reg[2])<<8 | uint32(reg[4]&3)<<16
+	phase := uint32(reg[1]) | uint32(reg[3])<<8 | uint32(reg[5])<<16
+	length := 256 - uint32(reg[4]&0xFC)
+	phase = (phase + freq) % (length << 16)
+	reg[1] = uint8(phase)
+	reg[3] = uint8(phase >> 8)
+	reg[5] = uint8(phase >> 16)
+	sample := n.Sample(uint8(phase>>16) + reg[6])
+	n.Outputs[ch] = (float32(sample) - 8) * float32(reg[7]&0x0F)
+}
+
+// 当前的输出电平 范围 -1-1 分时复用的效果等价于对启用的通道求平均
+func (n *Namco163) Output() float32 {
+	if n.Disable {
+		return 0
+	}
+	num := n.ChannelNum()
+	res := float32(0)
+	for ch := 8 - num; ch < 8; ch++ {
+		res += n.Outputs[ch]
+	}
+	return res / float32(num) / 120
+}
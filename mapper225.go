@@ -0,0 +1,284 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Multicart====================
+// 盗版合卡的公共部分 16k 粒度的 PRG bank 与 8k 粒度的 CHR bank 外部 bank 一般编码在写入的地址中
+
+type Multicart struct {
+	*Cartridge
+	PrgBanks int    // 16k 的 PRG bank 数目
+	ChrBanks int    // 8k 的 CHR bank 数目
+	PrgBank  [2]int // $8000 $C000
+	ChrBank  int
+}
+
+func NewMulticart(cartridge *Cartridge) *Multicart {
+	return &Multicart{Cartridge: cartridge, PrgBanks: len(cartridge.PRG) / 0x4000, ChrBanks: len(cartridge.CHR) / 0x2000,
+		PrgBank: [2]int{0, 1}}
+}
+
+func (m *Multicart) SetPrg16(lo, hi int) {
+	m.PrgBank = [2]int{lo, hi}
+}
+
+func (m *Multicart) SetPrg32(bank int) {
+	m.PrgBank = [2]int{bank * 2, bank*2 + 1}
+}
+
+func (m *Multicart) SetMirror(horizontal bool) {
+	if horizontal {
+		m.Cartridge.Mirror = MirrorHorizontal
+	} else {
+		m.Cartridge.Mirror = MirrorVertical
+	}
+}
+
+func (m *Multicart) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		index := (m.ChrBank%m.ChrBanks)*0x2000 + int(addr)
+		return m.CHR[index]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x4000] % m.PrgBanks
+		return m.PRG[bank*0x4000+int(addr%0x4000)]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Multicart) WriteChr(addr uint16, val uint8) {
+	index := (m.ChrBank%m.ChrBanks)*0x2000 + int(addr)
+	m.CHR[index] = val
+}
+
+//=====================Mapper225====================
+// 52/64/72-in-1 写入地址 A~[.HMS PPPP PPCC CCCC] H 为 PRG 与 CHR 的最高位 M 镜像 S 16k 模式
+// $5800-$5FFF 有 4 个 4bit 的 RAM
+
+type Mapper225 struct {
+	*Multicart
+	RAM [4]uint8
+}
+
+func NewMapper225(cartridge *Cartridge) Mapper {
+	return &Mapper225{Multicart: NewMulticart(cartridge)}
+}
+
+func (m *Mapper225) Read(addr uint16, debug bool) uint8 {
+	if addr >= 0x5800 && addr < 0x6000 {
+		return m.RAM[addr&3] & 0x0F
+	}
+	return m.Multicart.Read(addr, debug)
+}
+
+func (m *Mapper225) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		high := int(addr>>14) & 1
+		prg := int(addr>>6)&0x3F | high<<6
+		if addr&0x1000 != 0 {
+			m.SetPrg16(prg, prg)
+		} else {
+			m.SetPrg32(prg >> 1)
+		}
+		m.ChrBank = int(addr&0x3F) | high<<6
+		m.SetMirror(addr&0x2000 != 0)
+	case addr >= 0x5800 && addr < 0x6000:
+		m.RAM[addr&3] = val & 0x0F
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//=====================Mapper226====================
+// 76-in-1 等 $8000 与 $8001 两个寄存器 CHR 为 8k RAM
+
+type Mapper226 struct {
+	*Multicart
+	Regs [2]uint8
+}
+
+func NewMapper226(cartridge *Cartridge) Mapper {
+	return &Mapper226{Multicart: NewMulticart(cartridge)}
+}
+
+func (m *Mapper226) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		m.Regs[addr&1] = val
+		r0, r1 := m.Regs[0], m.Regs[1]
+		prg := int(r0&0x1F) | int(r0&0x80)>>2 | int(r1&1)<<6
+		if r0&0x20 != 0 {
+			m.SetPrg16(prg, prg)
+		} else {
+			m.SetPrg32(prg >> 1)
+		}
+		m.SetMirror(r0&0x40 == 0) // bit6 为 1 时垂直镜像
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//=====================Mapper227====================
+// 1200-in-1 等 写入地址 A~[.... .LPO MPPP PPMS] 支持类似 UNROM 的固定最后一个 bank 的模式
+
+type Mapper227 struct {
+	*Multicart
+}
+
+func NewMapper227(cartridge *Cartridge) Mapper {
+	return &Mapper227{NewMulticart(cartridge)}
+}
+
+func (m *Mapper227) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		s := addr&1 != 0
+		prg := int(addr>>2)&0x1F | int(addr&0x100)>>3
+		last := addr&0x200 != 0
+		switch {
+		case addr&0x80 != 0 && s: // 32k
+			m.SetPrg32(prg >> 1)
+		case addr&0x80 != 0: // 16k 镜像
+			m.SetPrg16(prg, prg)
+		default: // 类似 UNROM $C000 固定为 8 个 bank 一组中的第一个或最后一个
+			lo := prg
+			if s {
+				lo = prg & 0x3E
+			}
+			if last {
+				m.SetPrg16(lo, prg|7)
+			} else {
+				m.SetPrg16(lo, prg&0x38)
+			}
+		}
+		m.SetMirror(addr&2 != 0)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//=====================Mapper228====================
+// Action 52 / Cheetahmen II 写入地址 A~[..MH HPPP PPO. CCCC] 数据的低 2 位为 CHR 低位
+// 3 块 512k 的 PRG 芯片编号为 0 1 3，$4020-$5FFF 有 4 个 4bit 的寄存器 RAM
+
+type Mapper228 struct {
+	*Multicart
+	RAM [4]uint8
+}
+
+func NewMapper228(cartridge *Cartridge) Mapper {
+	return &Mapper228{Multicart: NewMulticart(cartridge)}
+}
+
+func (m *Mapper228) Read(addr uint16, debug bool) uint8 {
+	if addr >= 0x4020 && addr < 0x6000 {
+		return m.RAM[addr&3] & 0x0F
+	}
+	return m.Multicart.Read(addr, debug)
+}
+
+func (m *Mapper228) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		chip := int(addr>>11) & 3
+		if chip == 3 { // 芯片 2 不存在 芯片 3 在文件中紧接着芯片 1
+			chip = 2
+		}
+		prg := int(addr>>6)&0x1F | chip<<5
+		if addr&0x20 != 0 {
+			m.SetPrg16(prg, prg)
+		} else {
+			m.SetPrg32(prg >> 1)
+		}
+		m.ChrBank = int(addr&0x0F)<<2 | int(val&3)
+		m.SetMirror(addr&0x2000 != 0)
+	case addr >= 0x4020 && addr < 0x6000:
+		m.RAM[addr&3] = val & 0x0F
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//=====================Mapper233====================
+// 42-in-1 数据 [MMOP PPPP] O 为 16k 模式 镜像支持三屏模式 每次复位切换外部 512k bank
+
+type Mapper233 struct {
+	*Multicart
+	Bus        *Bus
+	Outer      int   // 复位切换的外部 bank
+	Latch      uint8 // 最后一次写入的值
+	MirrorMode uint8 // 0: 三屏 1: 垂直 2: 水平 3: 单屏
+}
+
+func NewMapper233(bus *Bus) Mapper {
+	m := &Mapper233{Multicart: NewMulticart(bus.Cartridge), Bus: bus}
+	m.Update()
+	return m
+}
+
+func (m *Mapper233) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.WriteChr(addr, val)
+	case addr >= 0x8000:
+		m.Latch = val
+		m.Update()
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper233) Update() {
+	prg := int(m.Latch&0x1F) | m.Outer<<5
+	if m.Latch&0x20 != 0 {
+		m.SetPrg16(prg, prg)
+	} else {
+		m.SetPrg32(prg >> 1)
+	}
+	m.MirrorMode = m.Latch >> 6
+}
+
+func (m *Mapper233) Reset() {
+	m.Outer ^= 1
+	m.Latch = 0
+	m.Update()
+}
+
+// 三屏模式 前 3 个 nametable 使用 CIRAM 0 最后一个使用 CIRAM 1
+func (m *Mapper233) NameTableAddr(addr uint16) int {
+	switch m.MirrorMode {
+	case 0:
+		table := (addr - 0x2000) / 0x0400 % 4
+		if table == 3 {
+			return 0x0400 + int(addr%0x0400)
+		}
+		return int(addr % 0x0400)
+	case 1:
+		return int(MirrorAddr(MirrorVertical, addr) % 2048)
+	case 2:
+		return int(MirrorAddr(MirrorHorizontal, addr) % 2048)
+	default:
+		return int(MirrorAddr(MirrorSingle1, addr) % 2048)
+	}
+}
+
+func (m *Mapper233) ReadNameTable(addr uint16) uint8 {
+	return m.Bus.PPU.NameTable[m.NameTableAddr(addr)]
+}
+
+func (m *Mapper233) WriteNameTable(addr uint16, val uint8) {
+	m.Bus.PPU.NameTable[m.NameTableAddr(addr)] = val
+}
@@ -0,0 +1,177 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Mapper32====================
+// Irem G-101 2 个可切换的 8k PRG bank 与 8 个 1k CHR bank submapper 1 (Major League) 固定单屏镜像
+
+type Mapper32 struct {
+	*Cartridge
+	PrgBanks int    // 8k 的 PRG bank 数目
+	ChrBanks int    // 1k 的 CHR bank 数目
+	PrgMode  uint8  // 0: $8000 可切换 $C000 固定; 1: 反过来
+	PrgReg   [2]int // $8000 与 $A000 写入的 bank
+	ChrBank  [8]int
+}
+
+func NewMapper32(cartridge *Cartridge) Mapper {
+	if cartridge.Submapper == 1 {
+		cartridge.Mirror = MirrorSingle0
+	}
+	return &Mapper32{Cartridge: cartridge, PrgBanks: len(cartridge.PRG) / 0x2000, ChrBanks: len(cartridge.CHR) / 0x0400}
+}
+
+func (m *Mapper32) PrgBank(addr uint16) int {
+	switch {
+	case addr >= 0xE000:
+		return m.PrgBanks - 1
+	case addr >= 0xC000:
+		if m.PrgMode == 0 {
+			return m.PrgBanks - 2
+		}
+		return m.PrgReg[0]
+	case addr >= 0xA000:
+		return m.PrgReg[1]
+	default:
+		if m.PrgMode == 0 {
+			return m.PrgReg[0]
+		}
+		return m.PrgBanks - 2
+	}
+}
+
+func (m *Mapper32) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0x8000:
+		bank := m.PrgBank(addr) % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper32) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0xC000: // $C000 之后没有寄存器
+	case addr >= 0xB000:
+		m.ChrBank[addr&7] = int(val)
+	case addr >= 0xA000:
+		m.PrgReg[1] = int(val & 0x1F)
+	case addr >= 0x9000:
+		if m.Submapper == 1 {
+			return
+		}
+		m.PrgMode = (val >> 1) & 1
+		if val&1 == 0 {
+			m.Cartridge.Mirror = MirrorVertical
+		} else {
+			m.Cartridge.Mirror = MirrorHorizontal
+		}
+	case addr >= 0x8000:
+		m.PrgReg[0] = int(val & 0x1F)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+//=====================Mapper65====================
+// Irem H3001 3 个可切换的 8k PRG bank，8 个 1k CHR bank，16bit cpu 周期 IRQ 计数器
+
+type Mapper65 struct {
+	*Cartridge
+	Bus        *Bus
+	PrgBanks   int
+	ChrBanks   int
+	PrgBank    [3]int // $8000 $A000 $C000 $E000 固定为最后一个
+	ChrBank    [8]int
+	IrqEnable  bool
+	IrqCounter uint16
+	IrqLatch   uint16
+	IrqPending bool
+}
+
+func NewMapper65(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	return &Mapper65{Cartridge: cartridge, Bus: bus, PrgBanks: len(cartridge.PRG) / 0x2000,
+		ChrBanks: len(cartridge.CHR) / 0x0400, PrgBank: [3]int{0, 1, 0xFE}}
+}
+
+func (m *Mapper65) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xE000:
+		return m.PRG[(m.PrgBanks-1)*0x2000+int(addr-0xE000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper65) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0xD000: // $D000 之后没有寄存器
+	case addr >= 0xC000:
+		m.PrgBank[2] = int(val)
+	case addr >= 0xB000:
+		m.ChrBank[addr&7] = int(val)
+	case addr >= 0xA000:
+		m.PrgBank[1] = int(val)
+	case addr >= 0x9000:
+		m.WriteControl(addr, val)
+	case addr >= 0x8000:
+		m.PrgBank[0] = int(val)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+// $9000-$9007 镜像与 IRQ 控制
+func (m *Mapper65) WriteControl(addr uint16, val uint8) {
+	switch addr & 7 {
+	case 1:
+		if val&0x80 == 0 {
+			m.Cartridge.Mirror = MirrorVertical
+		} else {
+			m.Cartridge.Mirror = MirrorHorizontal
+		}
+	case 3: // 写入同时确认 IRQ
+		m.IrqEnable = val&0x80 != 0
+		m.IrqPending = false
+	case 4:
+		m.IrqCounter = m.IrqLatch
+		m.IrqPending = false
+	case 5:
+		m.IrqLatch = (m.IrqLatch & 0x00FF) | uint16(val)<<8
+	case 6:
+		m.IrqLatch = (m.IrqLatch & 0xFF00) | uint16(val)
+	}
+}
+
+func (m *Mapper65) Step() {
+	if m.IrqEnable && m.IrqCounter > 0 { // 递减到 0 时触发并停止
+		m.IrqCounter--
+		if m.IrqCounter == 0 {
+			m.IrqPending = true
+		}
+	}
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
@@ -0,0 +1,208 @@
+package nes
+
+import (
+	"fmt"
+)
+
+//=====================Mapper33====================
+// Taito TC0190 (33) 与 TC0690 (48) 2 个可切换的 8k PRG bank，2 个 2k 与 4 个 1k CHR bank
+// TC0690 的镜像控制移到了 $E000 且多了类似 MMC3 的扫描线 IRQ
+
+type Mapper33 struct {
+	*Cartridge
+	Bus        *Bus
+	PrgBanks   int    // 8k 的 PRG bank 数目
+	ChrBanks   int    // 1k 的 CHR bank 数目
+	PrgBank    [2]int // $8000 $A000 $C000 $E000 固定为最后 2 个
+	ChrBank    [8]int
+	IrqLatch   uint8
+	IrqCounter uint8
+	IrqReload  bool
+	IrqEnable  bool
+	IrqPending bool
+}
+
+func NewMapper33(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	return &Mapper33{Cartridge: cartridge, Bus: bus, PrgBanks: len(cartridge.PRG) / 0x2000,
+		ChrBanks: len(cartridge.CHR) / 0x0400}
+}
+
+func (m *Mapper33) IsTC0690() bool {
+	return m.Mapper == 48
+}
+
+func (m *Mapper33) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xC000:
+		bank := m.PrgBanks - 2 + int(addr-0xC000)/0x2000
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper33) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0x8000:
+		m.WriteRegister(addr, val)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper33) WriteRegister(addr uint16, val uint8) {
+	switch addr & 0xE003 {
+	case 0x8000:
+		if m.IsTC0690() {
+			m.PrgBank[0] = int(val & 0x3F)
+			return
+		}
+		m.PrgBank[0] = int(val & 0x1F)
+		m.SetMirror(val & 0x40)
+	case 0x8001:
+		m.PrgBank[1] = int(val & 0x3F)
+	case 0x8002: // 2 个 2k CHR bank
+		m.ChrBank[0] = int(val) * 2
+		m.ChrBank[1] = int(val)*2 + 1
+	case 0x8003:
+		m.ChrBank[2] = int(val) * 2
+		m.ChrBank[3] = int(val)*2 + 1
+	case 0xA000, 0xA001, 0xA002, 0xA003: // 4 个 1k CHR bank
+		m.ChrBank[4+(addr&3)] = int(val)
+	case 0xC000: // TC0690 写入的锁存值是取反的
+		m.IrqLatch = val ^ 0xFF
+	case 0xC001:
+		m.IrqCounter = 0
+		m.IrqReload = true
+	case 0xC002:
+		m.IrqEnable = true
+	case 0xC003:
+		m.IrqEnable = false
+		m.IrqPending = false
+	case 0xE000:
+		if m.IsTC0690() {
+			m.SetMirror(val & 0x40)
+		}
+	}
+}
+
+func (m *Mapper33) SetMirror(horizontal uint8) {
+	if horizontal == 0 {
+		m.Cartridge.Mirror = MirrorVertical
+	} else {
+		m.Cartridge.Mirror = MirrorHorizontal
+	}
+}
+
+// TC0690 的扫描线计数与 MMC3 一致
+func (m *Mapper33) Scanline() {
+	if !m.IsTC0690() {
+		return
+	}
+	if m.IrqCounter == 0 || m.IrqReload {
+		m.IrqCounter = m.IrqLatch
+		m.IrqReload = false
+	} else {
+		m.IrqCounter--
+	}
+	if m.IrqCounter == 0 && m.IrqEnable {
+		m.IrqPending = true
+	}
+}
+
+func (m *Mapper33) Step() {
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
+
+//=====================Mapper80====================
+// Taito X1-005 寄存器位于 $7EF0-$7EFF，带 128byte 的内部 RAM
+
+type Mapper80 struct {
+	*Cartridge
+	PrgBanks  int
+	ChrBanks  int
+	PrgBank   [3]int // $8000 $A000 $C000 $E000 固定为最后一个
+	ChrBank   [8]int
+	RAM       [128]uint8 // $7F00-$7FFF 镜像一次
+	RamEnable bool       // 写入 $A3 时才开启
+}
+
+func NewMapper80(cartridge *Cartridge) Mapper {
+	return &Mapper80{Cartridge: cartridge, PrgBanks: len(cartridge.PRG) / 0x2000, ChrBanks: len(cartridge.CHR) / 0x0400}
+}
+
+func (m *Mapper80) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xE000:
+		return m.PRG[(m.PrgBanks-1)*0x2000+int(addr-0xE000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x7F00:
+		if m.RamEnable {
+			return m.RAM[addr&0x7F]
+		}
+		return 0
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper80) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0x8000: // 寄存器都在 $7EF0-$7EFF
+	case addr >= 0x7F00:
+		if m.RamEnable {
+			m.RAM[addr&0x7F] = val
+		}
+	case addr >= 0x7EF0:
+		m.WriteRegister(addr, val)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper80) WriteRegister(addr uint16, val uint8) {
+	switch addr {
+	case 0x7EF0, 0x7EF1: // 2 个 2k CHR bank 忽略最低位
+		i := int(addr-0x7EF0) * 2
+		m.ChrBank[i] = int(val &^ 1)
+		m.ChrBank[i+1] = int(val | 1)
+	case 0x7EF2, 0x7EF3, 0x7EF4, 0x7EF5:
+		m.ChrBank[4+int(addr-0x7EF2)] = int(val)
+	case 0x7EF6, 0x7EF7:
+		if val&1 == 0 {
+			m.Cartridge.Mirror = MirrorHorizontal
+		} else {
+			m.Cartridge.Mirror = MirrorVertical
+		}
+	case 0x7EF8, 0x7EF9:
+		m.RamEnable = val == 0xA3
+	case 0x7EFA, 0x7EFB:
+		m.PrgBank[0] = int(val)
+	case 0x7EFC, 0x7EFD:
+		m.PrgBank[1] = int(val)
+	case 0x7EFE, 0x7EFF:
+		m.PrgBank[2] = int(val)
+	}
+}
@@ -0,0 +1,297 @@
+package nes
+
+import (
+	"fmt"
+)
+
+// MMC3 衍生的板子类型
+const (
+	BoardMMC3     = 0 // 普通的 MMC3 (TxROM)
+	BoardMMC6     = 1 // 1k 内部 RAM 分成两半单独控制读写
+	BoardTxSROM   = 2 // 118 CHR bank 的 bit7 控制 nametable 映射
+	BoardTQROM    = 3 // 119 CHR bank 的 bit6 选择 CHR-RAM
+	BoardNamco108 = 4 // 206 没有 IRQ 与镜像控制
+	BoardNamco76  = 5 // 76 使用 2k 的 CHR bank
+	BoardNamco88  = 6 // 88 $0000 与 $1000 分别使用 CHR 的前后 64k
+	BoardNamco95  = 7 // 95 CHR bank 的 bit5 控制 nametable 映射
+	BoardNamco154 = 8 // 154 同 88 且写入的 bit6 控制单屏镜像
+)
+
+//=====================Mapper4====================
+// MMC3 与其衍生板子 8 个 bank 寄存器，2 种 PRG 模式与 2 种 CHR 模式，使用扫描线计数触发 IRQ
+
+type Mapper4 struct {
+	*Cartridge
+	Bus        *Bus
+	Board      uint8
+	PrgBanks   int    // 8k 的 PRG bank 数目
+	ChrBanks   int    // 1k 的 CHR bank 数目
+	Register   uint8  // $8000 选择的寄存器
+	Registers  [8]int // R0-R7
+	PrgMode    uint8  // 0: $8000 可切换 $C000 固定为倒数第二个; 1: 反过来
+	ChrMode    uint8  // 0: $0000 为 2 个 2k bank; 1: $1000 为 2 个 2k bank
+	PrgBank    [4]int // 4 个 8k 的 PRG bank
+	ChrBank    [8]int // 8 个 1k 的 CHR bank (未取模的原始值 部分板子高位有其他用途)
+	SRAM       []byte // $6000 的 8k RAM MMC6 只有 1k
+	RamProtect uint8  // $A001 RAM 保护
+	RamEnable  bool   // MMC6 $8000 bit5 RAM 总开关
+	ChrRam     []byte // TQROM 上额外的 8k CHR-RAM
+	IrqLatch   uint8  // 计数器重载值
+	IrqCounter uint8  // 扫描线计数器
+	IrqReload  bool   // 下一次计数时重载
+	IrqEnable  bool
+	IrqPending bool
+}
+
+func NewMapper4(bus *Bus) Mapper {
+	board := uint8(BoardMMC3)
+	if bus.Cartridge.Submapper == 1 {
+		board = BoardMMC6
+	}
+	return NewMapper4Board(bus, board)
+}
+
+func NewMapper4Board(bus *Bus, board uint8) Mapper {
+	cartridge := bus.Cartridge
+	m := &Mapper4{Cartridge: cartridge, Bus: bus, Board: board, PrgBanks: len(cartridge.PRG) / 0x2000,
+		ChrBanks: len(cartridge.CHR) / 0x0400, RamProtect: 0x80}
+	switch board {
+	case BoardMMC6:
+		m.SRAM = make([]byte, 0x0400)
+	case BoardTQROM:
+		m.SRAM = make([]byte, 0x2000)
+		m.ChrRam = make([]byte, 0x2000)
+	default:
+		m.SRAM = make([]byte, 0x2000)
+	}
+	m.Registers = [8]int{0, 2, 4, 5, 6, 7, 0, 1}
+	m.UpdateBanks()
+	return m
+}
+
+// Namco 108 系列没有 IRQ 与 RAM，寄存器只在 $8000-$9FFF
+func (m *Mapper4) IsNamco() bool {
+	return m.Board >= BoardNamco108
+}
+
+func (m *Mapper4) UpdateBanks() {
+	r := m.Registers
+	if m.IsNamco() { // Namco 108 只有模式 0 且寄存器位数更少
+		m.PrgMode, m.ChrMode = 0, 0
+		for i := 0; i < 6; i++ {
+			r[i] &= 0x3F
+		}
+		r[6] &= 0x0F
+		r[7] &= 0x0F
+	}
+	if m.PrgMode == 0 {
+		m.PrgBank = [4]int{r[6], r[7], m.PrgBanks - 2, m.PrgBanks - 1}
+	} else {
+		m.PrgBank = [4]int{m.PrgBanks - 2, r[7], r[6], m.PrgBanks - 1}
+	}
+	lo := [4]int{r[0] &^ 1, r[0] | 1, r[1] &^ 1, r[1] | 1}
+	hi := [4]int{r[2], r[3], r[4], r[5]}
+	switch m.Board {
+	case BoardNamco76: // R2-R5 作为 4 个 2k bank
+		lo = [4]int{r[2] * 2, r[2]*2 + 1, r[3] * 2, r[3]*2 + 1}
+		hi = [4]int{r[4] * 2, r[4]*2 + 1, r[5] * 2, r[5]*2 + 1}
+	case BoardNamco88, BoardNamco154: // $0000 只能使用前 64k $1000 只能使用后 64k
+		for i := 0; i < 4; i++ {
+			hi[i] |= 0x40
+		}
+	}
+	if m.ChrMode == 0 {
+		copy(m.ChrBank[:4], lo[:])
+		copy(m.ChrBank[4:], hi[:])
+	} else {
+		copy(m.ChrBank[:4], hi[:])
+		copy(m.ChrBank[4:], lo[:])
+	}
+}
+
+// 获取 CHR 地址对应的存储与下标
+func (m *Mapper4) ChrAddr(addr uint16) ([]byte, int) {
+	bank := m.ChrBank[addr/0x0400]
+	switch m.Board {
+	case BoardTxSROM: // bit7 用于 nametable
+		bank &= 0x7F
+	case BoardTQROM:
+		if bank&0x40 != 0 { // bit6 选择 CHR-RAM
+			return m.ChrRam, (bank&7)*0x0400 + int(addr%0x0400)
+		}
+	}
+	return m.CHR, (bank%m.ChrBanks)*0x0400 + int(addr%0x0400)
+}
+
+func (m *Mapper4) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		chr, index := m.ChrAddr(addr)
+		return chr[index]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x8000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x6000:
+		return m.ReadRam(addr)
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper4) ReadRam(addr uint16) uint8 {
+	switch {
+	case m.IsNamco():
+		return 0
+	case m.Board == BoardMMC6: // 1k RAM 映射在 $7000-$7FFF 前后 512byte 分别控制
+		if addr < 0x7000 || !m.RamEnable {
+			return 0
+		}
+		index := addr % 0x0400
+		if (index < 0x0200 && m.RamProtect&0x20 != 0) || (index >= 0x0200 && m.RamProtect&0x80 != 0) {
+			return m.SRAM[index]
+		}
+		return 0
+	case m.RamProtect&0x80 != 0:
+		return m.SRAM[addr-0x6000]
+	}
+	return 0
+}
+
+func (m *Mapper4) WriteRam(addr uint16, val uint8) {
+	switch {
+	case m.IsNamco():
+	case m.Board == BoardMMC6:
+		if addr < 0x7000 || !m.RamEnable {
+			return
+		}
+		index := addr % 0x0400
+		if (index < 0x0200 && m.RamProtect&0x10 != 0) || (index >= 0x0200 && m.RamProtect&0x40 != 0) {
+			m.SRAM[index] = val
+		}
+	case m.RamProtect&0xC0 == 0x80: // 开启且没有写保护
+		m.SRAM[addr-0x6000] = val
+	}
+}
+
+func (m *Mapper4) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		chr, index := m.ChrAddr(addr)
+		chr[index] = val
+	case addr >= 0x8000:
+		if m.IsNamco() {
+			m.WriteNamco(addr, val)
+		} else {
+			m.WriteRegister(addr, val)
+		}
+	case addr >= 0x6000:
+		m.WriteRam(addr, val)
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+func (m *Mapper4) WriteRegister(addr uint16, val uint8) {
+	even := addr%2 == 0
+	switch {
+	case addr < 0xA000 && even: // bank select
+		m.Register = val & 7
+		m.PrgMode = (val >> 6) & 1
+		m.ChrMode = (val >> 7) & 1
+		if m.Board == BoardMMC6 {
+			m.RamEnable = val&0x20 != 0
+		}
+		m.UpdateBanks()
+	case addr < 0xA000: // bank data
+		m.Registers[m.Register] = int(val)
+		m.UpdateBanks()
+	case addr < 0xC000 && even:
+		if m.Board == BoardTxSROM { // TxSROM 的镜像由 CHR bank 控制
+			return
+		}
+		if val&1 == 0 {
+			m.Cartridge.Mirror = MirrorVertical
+		} else {
+			m.Cartridge.Mirror = MirrorHorizontal
+		}
+	case addr < 0xC000:
+		if m.Board == BoardMMC6 && !m.RamEnable { // MMC6 需要先打开总开关
+			return
+		}
+		m.RamProtect = val
+	case addr < 0xE000 && even:
+		m.IrqLatch = val
+	case addr < 0xE000:
+		m.IrqCounter = 0
+		m.IrqReload = true
+	case even: // 关闭同时确认 IRQ
+		m.IrqEnable = false
+		m.IrqPending = false
+	default:
+		m.IrqEnable = true
+	}
+}
+
+func (m *Mapper4) WriteNamco(addr uint16, val uint8) {
+	if m.Board == BoardNamco154 { // 所有写入的 bit6 都会控制单屏镜像
+		if val&0x40 == 0 {
+			m.Cartridge.Mirror = MirrorSingle0
+		} else {
+			m.Cartridge.Mirror = MirrorSingle1
+		}
+	}
+	if addr >= 0xA000 {
+		return
+	}
+	if addr%2 == 0 {
+		m.Register = val & 7
+	} else {
+		m.Registers[m.Register] = int(val)
+		m.UpdateBanks()
+	}
+}
+
+// 获取 nametable 对应的 CIRAM 页 TxSROM 与 Namco 95 由 CHR bank 的高位控制
+func (m *Mapper4) NameTableAddr(addr uint16) int {
+	table := (addr - 0x2000) / 0x0400 % 4
+	switch m.Board {
+	case BoardTxSROM:
+		page := (m.ChrBank[table] >> 7) & 1
+		return page*0x0400 + int(addr%0x0400)
+	case BoardNamco95:
+		page := (m.ChrBank[table/2*2] >> 5) & 1 // nametable 0 1 使用 R0 2 3 使用 R1
+		return page*0x0400 + int(addr%0x0400)
+	}
+	return int(MirrorAddr(m.Cartridge.Mirror, addr) % 2048)
+}
+
+func (m *Mapper4) ReadNameTable(addr uint16) uint8 {
+	return m.Bus.PPU.NameTable[m.NameTableAddr(addr)]
+}
+
+func (m *Mapper4) WriteNameTable(addr uint16, val uint8) {
+	m.Bus.PPU.NameTable[m.NameTableAddr(addr)] = val
+}
+
+// 模拟 PPU A12 上升沿 每条渲染的扫描线计数一次
+func (m *Mapper4) Scanline() {
+	if m.IsNamco() {
+		return
+	}
+	if m.IrqCounter == 0 || m.IrqReload {
+		m.IrqCounter = m.IrqLatch
+		m.IrqReload = false
+	} else {
+		m.IrqCounter--
+	}
+	if m.IrqCounter == 0 && m.IrqEnable {
+		m.IrqPending = true
+	}
+}
+
+func (m *Mapper4) Step() {
+	if m.IrqPending {
+		m.Bus.CPU.TriggerIRQ()
+	}
+}
@@ -0,0 +1,289 @@
+package nes
+
+import (
+	"fmt"
+	"math"
+)
+
+//=====================Mapper69====================
+// Sunsoft FME-7 / 5B  通过命令寄存器+参数寄存器配置 bank 5B 额外带了 3 通道的扩展音频
+
+type Mapper69 struct {
+	*Cartridge
+	Bus        *Bus
+	PrgBanks   int    // 8k 的 PRG bank 数目
+	ChrBanks   int    // 1k 的 CHR bank 数目
+	Command    uint8  // $8000 写入的命令
+	ChrBank    [8]int // 8 个 1k 的 CHR bank
+	PrgBank    [4]int // $6000 $8000 $A000 $C000 4 个 8k 的 PRG bank $E000 固定为最后一个
+	RamSelect  bool   // $6000 映射的是 RAM 还是 ROM
+	RamEnable  bool   // RAM 是否可以读写
+	SRAM       []byte // $6000 的 8k RAM
+	IrqEnable  bool   // 计数器溢出时是否触发 IRQ
+	IrqCounter bool   // 计数器是否进行递减
+	IrqValue   uint16 // 16bit 计数器 每个 cpu 周期递减一次
+	IrqPending bool   // IRQ 是否还未被确认
+	Sound      *Sunsoft5B
+}
+
+func NewMapper69(bus *Bus) Mapper {
+	cartridge := bus.Cartridge
+	prgBanks := len(cartridge.PRG) / 0x2000
+	m := &Mapper69{Cartridge: cartridge, Bus: bus, PrgBanks: prgBanks, ChrBanks: len(cartridge.CHR) / 0x0400,
+		SRAM: make([]byte, 0x2000), Sound: NewSunsoft5B()}
+	m.PrgBank[3] = prgBanks - 1
+	return m
+}
+
+func (m *Mapper69) Read(addr uint16, _ bool) uint8 {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		return m.CHR[bank*0x0400+int(addr%0x0400)]
+	case addr >= 0xE000:
+		return m.PRG[(m.PrgBanks-1)*0x2000+int(addr-0xE000)]
+	case addr >= 0x8000:
+		bank := m.PrgBank[(addr-0x6000)/0x2000] % m.PrgBanks
+		return m.PRG[bank*0x2000+int(addr%0x2000)]
+	case addr >= 0x6000:
+		if !m.RamSelect { // $6000 也可以映射为 ROM
+			bank := m.PrgBank[0] % m.PrgBanks
+			return m.PRG[bank*0x2000+int(addr-0x6000)]
+		}
+		if m.RamEnable {
+			return m.SRAM[addr-0x6000]
+		}
+		return 0 // RAM 未开启时为开路总线
+	default:
+		fmt.Printf("unsupport read addr %04X\n", addr)
+	}
+	return 0
+}
+
+func (m *Mapper69) Write(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		bank := m.ChrBank[addr/0x0400] % m.ChrBanks
+		m.CHR[bank*0x0400+int(addr%0x0400)] = val
+	case addr >= 0xE000: // 5B 音频数据
+		m.Sound.WriteData(val)
+	case addr >= 0xC000: // 5B 音频寄存器选择
+		m.Sound.WriteAddr(val)
+	case addr >= 0xA000:
+		m.WriteParam(val)
+	case addr >= 0x8000:
+		m.Command = val & 0x0F
+	case addr >= 0x6000:
+		if m.RamSelect && m.RamEnable {
+			m.SRAM[addr-0x6000] = val
+		}
+	default:
+		fmt.Printf("unsupport write addr %04X\n", addr)
+	}
+}
+
+// $A000 根据 Command 写入对应的参数
+func (m *Mapper69) WriteParam(val uint8) {
+	switch {
+	case m.Command < 8: // 1k CHR bank
+		m.ChrBank[m.Command] = int(val)
+	case m.Command == 8: // $6000 bank 同时控制 RAM
+		m.PrgBank[0] = int(val & 0x3F)
+		m.RamSelect = val&0x40 != 0
+		m.RamEnable = val&0x80 != 0
+	case m.Command < 0x0C: // $8000 $A000 $C000 的 8k PRG bank
+		m.PrgBank[m.Command-8] = int(val & 0x3F)
+	case m.Command == 0x0C:
+		switch val & 3 {
+		case 0:
+			m.Cartridge.Mirror = MirrorVertical
+		case 1:
+			m.Cartridge.Mirror = MirrorHorizontal
+		case 2:
+			m.Cartridge.Mirror = MirrorSingle0
+		case 3:
+			m.Cartridge.Mirror = MirrorSingle1
+		}
+	case m.Command == 0x0D: // 写入 IRQ 控制同时确认 IRQ
+		m.IrqEnable = val&0x01 != 0
+		m.IrqCounter = val&0x80 != 0
+		m.IrqPending = false
+	case m.Command == 0x0E:
+		m.IrqValue = (m.IrqValue & 0xFF00) | uint16(val)
+	case m.Command == 0x0F:
+		m.IrqValue = (m.IrqValue & 0x00FF) | uint16(val)<<8
+	}
+}
+
+func (m *Mapper69) Step() {
+	if m.IrqCounter {
+		m.IrqValue--
+		if m.IrqValue == 0xFFFF && m.IrqEnable { // 从 0 递减到 $FFFF 时触发
+			m.IrqPending = true
+		}
+	}
+	if m.IrqPending { // IRQ 是电平触发的，确认前需要一直保持
+		m.Bus.CPU.TriggerIRQ()
+	}
+	m.Sound.Step()
+}
+
+func (m *Mapper69) Audio() float32 {
+	return m.Sound.Output()
+}
+
+//=====================Sunsoft5B====================
+// 兼容 AY-3-8910 (YM2149F) 的 3 个方波通道 + 噪声 + 包络
+
+type Sunsoft5B struct {
+	Addr       uint8     // 当前选中的寄存器
+	Regs       [16]uint8 // 寄存器原始数据
+	Divider    int       // 16 个 cpu 周期推进一次 tone 与 noise
+	ToneCount  [3]uint16
+	ToneOut    [3]uint8
+	NoiseCount uint8
+	NoiseShift uint32 // 17bit LFSR
+	EnvDivider int    // 8 个 cpu 周期推进一次包络
+	EnvCount   uint16
+	EnvStep    uint8 // 0-31
+	EnvHold    bool
+	EnvFlip    bool  // alternate 模式下每轮翻转方向
+	EnvLevel   uint8 // 当前包络音量 0-31
+}
+
+var (
+	// 5B 音量每一级 1.5dB 共 32 级 (通道音量 4bit 对应其中的奇数级)
+	Sunsoft5BVolumes [32]float32
+)
+
+func init() {
+	for i := 1; i < 32; i++ {
+		Sunsoft5BVolumes[i] = float32(math.Pow(10, float64(i-31)*1.5/20))
+	}
+}
+
+func NewSunsoft5B() *Sunsoft5B {
+	return &Sunsoft5B{NoiseShift: 1}
+}
+
+// $C000
+func (s *Sunsoft5B) WriteAddr(val uint8) {
+	s.Addr = val
+}
+
+// $E000
+func (s *Sunsoft5B) WriteData(val uint8) {
+	if s.Addr&0xF0 != 0 { // 高 4 位不为 0 时写入被忽略
+		return
+	}
+	s.Regs[s.Addr] = val
+	if s.Addr == 0x0D { // 写入包络形状会重启包络
+		s.EnvStep = 0
+		s.EnvHold = false
+		s.EnvFlip = false
+		s.EnvCount = 0
+		s.UpdateEnvLevel()
+	}
+}
+
+func (s *Sunsoft5B) TonePeriod(ch int) uint16 {
+	return uint16(s.Regs[ch*2]) | uint16(s.Regs[ch*2+1]&0x0F)<<8
+}
+
+func (s *Sunsoft5B) EnvPeriod() uint16 {
+	return uint16(s.Regs[0x0B]) | uint16(s.Regs[0x0C])<<8
+}
+
+func (s *Sunsoft5B) Step() {
+	s.Divider++
+	if s.Divider >= 16 {
+		s.Divider = 0
+		for ch := 0; ch < 3; ch++ {
+			s.ToneCount[ch]++
+			if s.ToneCount[ch] >= s.TonePeriod(ch) {
+				s.ToneCount[ch] = 0
+				s.ToneOut[ch] ^= 1
+			}
+		}
+		s.NoiseCount++
+		if s.NoiseCount >= s.Regs[0x06]&0x1F {
+			s.NoiseCount = 0
+			// 17bit LFSR 反馈位为 bit0 ^ bit3
+			bit := (s.NoiseShift ^ (s.NoiseShift >> 3)) & 1
+			s.NoiseShift = (s.NoiseShift >> 1) | bit<<16
+		}
+	}
+	s.EnvDivider++
+	if s.EnvDivider >= 8 {
+		s.EnvDivider = 0
+		s.EnvCount++
+		if s.EnvCount >= s.EnvPeriod() {
+			s.EnvCount = 0
+			s.StepEnv()
+		}
+	}
+}
+
+// 推进包络 形状寄存器 bit3 continue bit2 attack bit1 alternate bit0 hold
+func (s *Sunsoft5B) StepEnv() {
+	if s.EnvHold {
+		return
+	}
+	s.EnvStep++
+	if s.EnvStep >= 32 {
+		shape := s.Regs[0x0D]
+		if shape&0x08 == 0 || shape&0x01 != 0 { // 不再继续 或需要保持
+			s.EnvHold = true
+			s.EnvStep = 31
+		} else {
+			s.EnvStep = 0
+			if shape&0x02 != 0 {
+				s.EnvFlip = !s.EnvFlip
+			}
+		}
+	}
+	s.UpdateEnvLevel()
+}
+
+func (s *Sunsoft5B) UpdateEnvLevel() {
+	shape := s.Regs[0x0D]
+	attack := (shape&0x04 != 0) != s.EnvFlip
+	if s.EnvHold {
+		switch {
+		case shape&0x08 == 0: // 不继续时最终都保持为 0
+			s.EnvLevel = 0
+			return
+		case shape&0x02 != 0: // hold + alternate 保持在反向的末尾
+			attack = !attack
+		}
+	}
+	level := s.EnvStep
+	if !attack {
+		level = 31 - level
+	}
+	s.EnvLevel = level
+}
+
+// 当前的输出电平 范围 0-1 (3 通道平均)
+func (s *Sunsoft5B) Output() float32 {
+	mixer := s.Regs[0x07]
+	noise := uint8(s.NoiseShift & 1)
+	res := float32(0)
+	for ch := 0; ch < 3; ch++ {
+		toneOff := (mixer>>ch)&1 == 1
+		noiseOff := (mixer>>(ch+3))&1 == 1
+		// 被关闭的部分视为一直为高电平
+		if (!toneOff && s.ToneOut[ch] == 0) || (!noiseOff && noise == 0) {
+			continue
+		}
+		vol := s.Regs[0x08+ch]
+		level := uint8(0)
+		if vol&0x10 != 0 { // 使用包络音量
+			level = s.EnvLevel
+		} else if vol&0x0F != 0 {
+			level = (vol&0x0F)*2 + 1
+		}
+		res += Sunsoft5BVolumes[level]
+	}
+	return res / 3
+}
@@ -0,0 +1,100 @@
+package nes
+
+import "testing"
+
+// PRG 全部填充为 fill 的测试卡带
+func NewTestCartridge(mapper uint16, submapper uint8, prg, chr int, fill uint8) *Cartridge {
+	cartridge := &Cartridge{Mapper: mapper, Submapper: submapper, PRG: make([]byte, prg), CHR: make([]byte, chr)}
+	for i := range cartridge.PRG {
+		cartridge.PRG[i] = fill
+	}
+	return cartridge
+}
+
+// 存在总线冲突时写入的值与 ROM 相与 submapper 1 2 覆盖板子的默认行为
+func TestBusConflict(t *testing.T) {
+	tests := []struct {
+		submapper uint8
+		want      int
+	}{{0, 1}, {1, 3}, {2, 1}}
+	for _, test := range tests {
+		mapper := NewMapper3(NewTestCartridge(3, test.submapper, 0x8000, 0x8000, 0x01)).(*Mapper3)
+		mapper.Write(0x8000, 0x03)
+		if mapper.ChrBank != test.want {
+			t.Errorf("mapper 3 submapper %d got chr bank %d want %d", test.submapper, mapper.ChrBank, test.want)
+		}
+	}
+	tests = []struct {
+		submapper uint8
+		want      int
+	}{{0, 3}, {1, 3}, {2, 1}}
+	for _, test := range tests {
+		mapper := NewMapper7(NewTestCartridge(7, test.submapper, 0x20000, 0x2000, 0x01)).(*Mapper7)
+		mapper.Write(0x8000, 0x03)
+		if mapper.PrgBank != test.want {
+			t.Errorf("mapper 7 submapper %d got prg bank %d want %d", test.submapper, mapper.PrgBank, test.want)
+		}
+	}
+	// 写入 1 与 ROM 相与后为 0 submapper 4 开启 CHR
+	mapper := NewMapper185(NewTestCartridge(185, 4, 0x8000, 0x2000, 0xFC)).(*Mapper185)
+	mapper.Write(0x8000, 0x01)
+	if !mapper.ChrEnable {
+		t.Error("mapper 185 should and the value with rom")
+	}
+}
+
+// 226 的 $8000 bit6 为 1 时垂直镜像 bit5 为 1 时两个 16k 相同
+func TestMapper226(t *testing.T) {
+	mapper := NewMapper226(NewTestCartridge(226, 0, 0x100000, 0x2000, 0)).(*Mapper226)
+	mapper.Write(0x8000, 0x40|0x20|0x03)
+	if mapper.Cartridge.Mirror != MirrorVertical {
+		t.Errorf("got mirror %d want vertical", mapper.Cartridge.Mirror)
+	}
+	if mapper.PrgBank != [2]int{3, 3} {
+		t.Errorf("got prg bank %v want [3 3]", mapper.PrgBank)
+	}
+	mapper.Write(0x8001, 0x01)
+	mapper.Write(0x8000, 0x04)
+	if mapper.Cartridge.Mirror != MirrorHorizontal {
+		t.Errorf("got mirror %d want horizontal", mapper.Cartridge.Mirror)
+	}
+	if mapper.PrgBank != [2]int{68, 69} {
+		t.Errorf("got prg bank %v want [68 69]", mapper.PrgBank)
+	}
+}
+
+type TestAudioMapper float32
+
+func (m TestAudioMapper) Audio() float32 {
+	return float32(m)
+}
+
+// 一帧的 cpu 周期对应 SampleRate/Fps 个采样 取走后清空
+func TestAudioSampling(t *testing.T) {
+	audio := NewAudio()
+	for cycles := 0; cycles < CPUFreq/Fps; cycles += 3 {
+		audio.Step(3, TestAudioMapper(1))
+	}
+	samples := audio.Read()
+	if Abs(len(samples)-SampleRate/Fps) > 1 || samples[0] != AudioVolume {
+		t.Errorf("got %d samples first %v", len(samples), samples[0])
+	}
+	if len(audio.Read()) != 0 {
+		t.Error("read should clear samples")
+	}
+}
+
+// debug 读取手柄与卡带空间没有副作用
+func TestDebugRead(t *testing.T) {
+	bus, err := NewBusCartridge(NewTestCartridge(0, 0, 0x8000, 0x2000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Input1.SetButtons(1 << ButtonA)
+	if bus.CPU.Read(0x4016, true) != 1 || bus.CPU.Read(0x4016, true) != 1 {
+		t.Error("debug read should not shift the controller")
+	}
+	if bus.Input1.Polled || bus.Input1.Index != 0 {
+		t.Errorf("debug read changed input %+v", bus.Input1)
+	}
+}
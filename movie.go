@@ -0,0 +1,390 @@
+package nes
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FM2 录像 与 FCEUX 的格式兼容 https://fceux.com/web/FM2.html
+// 每帧一行 |命令|1p|2p|| 按键从左到右为 RLDUTSBA 按下为字母 松开为 .
+// 从即时存档开始的录像在 savestate 中保存本模拟器的存档 其他模拟器无法读取
+// 延迟帧保存在文件头 lagCount 与 lagFrames 中 lagFrames 为帧号范围 例如 0-12,40 其他模拟器会忽略
+const (
+	MovieSoftReset = 1
+	MovieHardReset = 2
+	MovieFDSInsert = 4
+	MovieFDSSelect = 8
+)
+
+const MovieButtons = "RLDUTSBA"
+
+var ErrMovieBinary = errors.New("binary fm2 is not supported")
+
+type MovieFrame struct {
+	Command uint8
+	Buttons [2]uint8
+}
+
+type Movie struct {
+	Header   map[string]string // 除 comment subtitle 以外的文件头
+	Comments []string          // comment 与 subtitle 行 原样保留
+	State    []byte            // 起始即时存档 为空时从开机开始
+	Frames   []MovieFrame
+	Lag      []bool // 每一帧是否为延迟帧
+}
+
+// 文件头按这个顺序写出 其余的按字母顺序
+var MovieHeaderKeys = []string{"version", "emuVersion", "rerecordCount", "palFlag", "romFilename", "romChecksum",
+	"guid", "fourscore", "microphone", "port0", "port1", "port2", "FDS", "NewPPU"}
+
+// 从当前状态开始录制 fromState 为 false 时先重新开机
+func NewMovie(console *Console, fromState bool) (*Movie, error) {
+	if console.Bus == nil {
+		return nil, ErrNoRom
+	}
+	console.SetMovieMode(true)
+	movie := &Movie{Header: map[string]string{"version": "3", "emuVersion": "0", "rerecordCount": "0",
+		"palFlag": "0", "fourscore": "0", "microphone": "0", "port0": "1", "port1": "1", "port2": "0",
+		"FDS": "0", "NewPPU": "0"}}
+	if fromState {
+		buff := &bytes.Buffer{}
+		if err := console.SaveState(buff); err != nil {
+			return nil, err
+		}
+		movie.State = buff.Bytes()
+	} else if err := console.Power(); err != nil {
+		return nil, err
+	}
+	cartridge := console.Bus.Cartridge
+	if cartridge.Region == RegionPAL || cartridge.Region == RegionDendy {
+		movie.Header["palFlag"] = "1"
+	}
+	if len(cartridge.Disk) > 0 {
+		movie.Header["FDS"] = "1"
+	}
+	movie.Header["romFilename"] = strings.TrimSuffix(filepath.Base(cartridge.Path), filepath.Ext(cartridge.Path))
+	movie.Header["romChecksum"] = RomChecksum(MovieRom(console))
+	guid := make([]byte, 16)
+	rand.Read(guid)
+	movie.Header["guid"] = strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", guid[:4], guid[4:6], guid[6:8], guid[8:10],
+		guid[10:]))
+	return movie, nil
+}
+
+// FCEUX 使用 PRG+CHR 的 md5 CHR-RAM 会在运行中改变 不计算在内
+// FDS 的 PRG 为 BIOS 与 FCEUX 相同使用所有磁盘面的数据
+func RomChecksum(cartridge *Cartridge) string {
+	rom := append([]byte(nil), cartridge.PRG...)
+	if len(cartridge.Disk) > 0 {
+		rom = bytes.Join(cartridge.Disk, nil)
+	} else if cartridge.ChrRam == 0 {
+		rom = append(rom, cartridge.CHR...)
+	}
+	sum := md5.Sum(rom)
+	return "base64:" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// 运行中磁盘会被写入 使用没有运行过的卡带计算校验和
+func MovieRom(console *Console) *Cartridge {
+	if console.Rom != nil {
+		return console.Rom
+	}
+	return console.Bus.Cartridge
+}
+
+// rom 校验和不同时返回 ErrMovieChecksum
+func (m *Movie) CheckRom(console *Console) error {
+	if console.Bus == nil {
+		return ErrNoRom
+	}
+	rom := RomChecksum(MovieRom(console))
+	if checksum, ok := m.Header["romChecksum"]; ok && checksum != rom {
+		return &ErrMovieChecksum{Movie: checksum, Rom: rom}
+	}
+	return nil
+}
+
+// 开始回放 读取起始存档或重新开机 之后不再读写存档 rom 校验和由调用方通过 CheckRom 检查
+func (m *Movie) Start(console *Console) error {
+	if console.Bus == nil {
+		return ErrNoRom
+	}
+	console.SetMovieMode(true)
+	if len(m.State) > 0 {
+		return console.LoadState(bytes.NewReader(m.State))
+	}
+	return console.Power()
+}
+
+// 设置第 frame 帧的按键并执行命令 之后由调用者运行这一帧
+func (m *Movie) Apply(console *Console, frame int) error {
+	if frame < 0 || frame >= len(m.Frames) {
+		return fmt.Errorf("movie frame %d out of range %d", frame, len(m.Frames))
+	}
+	data := m.Frames[frame]
+	console.SetButtons(0, data.Buttons[0])
+	console.SetButtons(1, data.Buttons[1])
+	return ApplyCommand(console, data.Command)
+}
+
+// 记录当前的按键作为第 frame 帧 之后的帧被丢弃 命令会立即执行
+func (m *Movie) Record(console *Console, frame int, command uint8) error {
+	if frame < 0 || frame > len(m.Frames) {
+		return fmt.Errorf("movie frame %d out of range %d", frame, len(m.Frames))
+	}
+	if frame < len(m.Frames) { // 倒带后重新录制
+		m.Frames = m.Frames[:frame]
+		if len(m.Lag) > frame {
+			m.Lag = m.Lag[:frame]
+		}
+		m.AddRerecord()
+	}
+	m.Frames = append(m.Frames, MovieFrame{Command: command, Buttons: [2]uint8{console.Buttons(0), console.Buttons(1)}})
+	return ApplyCommand(console, command)
+}
+
+// 运行完第 frame 帧之后记录是否为延迟帧
+func (m *Movie) SetLag(frame int, lag bool) {
+	for len(m.Lag) <= frame {
+		m.Lag = append(m.Lag, false)
+	}
+	m.Lag[frame] = lag
+}
+
+func (m *Movie) LagCount() int {
+	count := 0
+	for i, lag := range m.Lag {
+		if lag && i < len(m.Frames) {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *Movie) AddRerecord() {
+	count, _ := strconv.Atoi(m.Header["rerecordCount"])
+	m.Header["rerecordCount"] = strconv.Itoa(count + 1)
+}
+
+// 执行录像中的命令 FDS 只支持换面 插入与选择都视为换到下一面
+func ApplyCommand(console *Console, command uint8) error {
+	if command&MovieHardReset != 0 {
+		if err := console.Power(); err != nil {
+			return err
+		}
+	} else if command&MovieSoftReset != 0 {
+		console.Reset()
+	}
+	if command&(MovieFDSInsert|MovieFDSSelect) != 0 {
+		if fds, ok := console.Bus.Mapper.(*MapperFDS); ok {
+			fds.SwitchSide()
+		}
+	}
+	return nil
+}
+
+func ReadFM2(reader io.Reader) (*Movie, error) {
+	movie := &Movie{Header: make(map[string]string)}
+	lagLine, lagValue := 0, ""
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // savestate 可能很长
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(text) == 0 {
+			continue
+		}
+		if text[0] == '|' {
+			frame, err := ParseFM2Frame(text)
+			if err != nil {
+				return nil, fmt.Errorf("fm2 line %d: %w", line, err)
+			}
+			movie.Frames = append(movie.Frames, frame)
+			continue
+		}
+		key, value := text, ""
+		if index := strings.IndexByte(text, ' '); index >= 0 {
+			key, value = text[:index], text[index+1:]
+		}
+		switch key {
+		case "comment", "subtitle":
+			movie.Comments = append(movie.Comments, text)
+		case "savestate":
+			state, err := DecodeFM2Bytes(value)
+			if err != nil {
+				return nil, fmt.Errorf("fm2 line %d: %w", line, err)
+			}
+			movie.State = state
+		case "lagFrames": // 读完所有帧后再解析 范围不能超出录像长度
+			lagLine, lagValue = line, value
+		case "lagCount": // 由 lagFrames 计算
+		case "binary":
+			if value != "0" {
+				return nil, ErrMovieBinary
+			}
+		default:
+			movie.Header[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if movie.Header["version"] != "3" {
+		return nil, fmt.Errorf("unsupported fm2 version %s", movie.Header["version"])
+	}
+	lag, err := ParseFrameRanges(lagValue, len(movie.Frames))
+	if err != nil {
+		return nil, fmt.Errorf("fm2 line %d: %w", lagLine, err)
+	}
+	movie.Lag = lag
+	return movie, nil
+}
+
+// |命令|1p|2p|...| 没有手柄的端口为空
+func ParseFM2Frame(text string) (MovieFrame, error) {
+	frame := MovieFrame{}
+	items := strings.Split(text, "|")
+	if len(items) < 3 {
+		return frame, fmt.Errorf("bad frame %s", text)
+	}
+	command, err := strconv.ParseUint(strings.TrimSpace(items[1]), 10, 8)
+	if err != nil {
+		return frame, fmt.Errorf("bad command %s", items[1])
+	}
+	frame.Command = uint8(command)
+	for i := 0; i < 2 && i+2 < len(items); i++ {
+		item := items[i+2]
+		if len(item) == 0 {
+			continue
+		}
+		if len(item) != len(MovieButtons) {
+			return frame, fmt.Errorf("bad buttons %s", item)
+		}
+		for j := 0; j < len(item); j++ { // 空格与 . 为松开
+			if item[j] != '.' && item[j] != ' ' {
+				frame.Buttons[i] |= 1 << (7 - j)
+			}
+		}
+	}
+	return frame, nil
+}
+
+func FormatFM2Frame(frame MovieFrame) string {
+	buff := &strings.Builder{}
+	buff.WriteString(fmt.Sprintf("|%d|", frame.Command))
+	for i := 0; i < 2; i++ {
+		for j := 0; j < len(MovieButtons); j++ {
+			if frame.Buttons[i]&(1<<(7-j)) != 0 {
+				buff.WriteByte(MovieButtons[j])
+			} else {
+				buff.WriteByte('.')
+			}
+		}
+		buff.WriteByte('|')
+	}
+	buff.WriteByte('|')
+	return buff.String()
+}
+
+// FCEUX 的二进制字段 base64: 开头或 0x 开头的十六进制
+func DecodeFM2Bytes(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "base64:") {
+		return base64.StdEncoding.DecodeString(value[len("base64:"):])
+	}
+	return hex.DecodeString(strings.TrimPrefix(value, "0x"))
+}
+
+func (m *Movie) WriteFM2(writer io.Writer) error {
+	buff := bufio.NewWriter(writer)
+	written := make(map[string]bool)
+	for _, key := range MovieHeaderKeys {
+		if value, ok := m.Header[key]; ok {
+			fmt.Fprintf(buff, "%s %s\n", key, value)
+			written[key] = true
+		}
+	}
+	keys := make([]string, 0)
+	for key := range m.Header {
+		if !written[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(buff, "%s %s\n", key, m.Header[key])
+	}
+	if len(m.Lag) > 0 {
+		fmt.Fprintf(buff, "lagCount %d\n", m.LagCount())
+		fmt.Fprintf(buff, "lagFrames %s\n", FormatFrameRanges(m.Lag, len(m.Frames)))
+	}
+	for _, comment := range m.Comments {
+		fmt.Fprintf(buff, "%s\n", comment)
+	}
+	if len(m.State) > 0 {
+		fmt.Fprintf(buff, "savestate base64:%s\n", base64.StdEncoding.EncodeToString(m.State))
+	}
+	for _, frame := range m.Frames {
+		fmt.Fprintf(buff, "%s\n", FormatFM2Frame(frame))
+	}
+	return buff.Flush()
+}
+
+// 连续的帧合并为范围 只输出前 count 帧
+func FormatFrameRanges(flags []bool, count int) string {
+	items := make([]string, 0)
+	for i := 0; i < len(flags) && i < count; i++ {
+		if !flags[i] {
+			continue
+		}
+		start := i
+		for i+1 < len(flags) && i+1 < count && flags[i+1] {
+			i++
+		}
+		if start == i {
+			items = append(items, strconv.Itoa(i))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", start, i))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// 超出 count 的帧被忽略 不会因为很大的帧号分配过多内存
+func ParseFrameRanges(value string, count int) ([]bool, error) {
+	res := make([]bool, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		start, end := item, item
+		if index := strings.IndexByte(item, '-'); index >= 0 {
+			start, end = item[:index], item[index+1:]
+		}
+		first, err1 := strconv.Atoi(start)
+		last, err2 := strconv.Atoi(end)
+		if err1 != nil || err2 != nil || first < 0 || last < first {
+			return nil, fmt.Errorf("bad frame range %s", item)
+		}
+		if first >= count {
+			continue
+		}
+		last = Min(last, count-1)
+		for len(res) <= last {
+			res = append(res, false)
+		}
+		for i := first; i <= last; i++ {
+			res[i] = true
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,286 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	IPSMagic = []byte("PATCH")
+	IPSEnd   = []byte("EOF")
+	UPSMagic = []byte("UPS1")
+	BPSMagic = []byte("BPS1")
+	PatchExt = []string{".ips", ".ups", ".bps"}
+
+	PatchNumberLimit = 16 << 20 // UPS BPS 中的大小与偏移不会超过 16M 超出视为损坏 避免分配过大的内存
+
+	ErrPatchTruncated = errors.New("patch truncated")
+	ErrPatchCrc       = errors.New("patch crc mismatch")
+	ErrPatchInvalid   = errors.New("patch invalid")
+)
+
+// 查找与 rom 同名的补丁文件 压缩包内的 rom 在压缩包旁边查找
+// 与压缩包同名或与包内文件同名的补丁 例如 x.zip#a.nes 查找 x.ips 与 a.ips x.nes.gz 查找 x.ips
+func FindPatches(path, entry string, archive bool) []string {
+	bases := []string{TrimExt(path)}
+	if archive {
+		if IsRomExt(bases[0]) { // x.nes.gz
+			bases[0] = TrimExt(bases[0])
+		}
+		if entry != "" {
+			bases = append(bases, filepath.Join(filepath.Dir(path), TrimExt(filepath.Base(entry))))
+		}
+	}
+	res := make([]string, 0)
+	for i, base := range bases {
+		if i > 0 && base == bases[0] {
+			continue
+		}
+		for _, ext := range PatchExt {
+			if _, err := os.Stat(base + ext); err == nil {
+				res = append(res, base+ext)
+			}
+		}
+	}
+	return res
+}
+
+func TrimExt(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// 依次应用补丁 补丁无法读取或损坏时返回错误 校验不通过时仍然应用 作为警告返回
+func ApplyPatches(data []byte, patches []string) ([]byte, []error, error) {
+	warnings := make([]error, 0)
+	for _, path := range patches {
+		patch, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		res, err := ApplyPatch(data, patch)
+		if errors.Is(err, ErrPatchCrc) && res != nil {
+			warnings = append(warnings, fmt.Errorf("%s: %w", path, err))
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("apply patch %s: %w", path, err)
+		}
+		data = res
+	}
+	return data, warnings, nil
+}
+
+func ApplyPatch(data []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, IPSMagic):
+		return ApplyIPS(data, patch)
+	case bytes.HasPrefix(patch, UPSMagic):
+		return ApplyUPS(data, patch)
+	case bytes.HasPrefix(patch, BPSMagic):
+		return ApplyBPS(data, patch)
+	}
+	return nil, errors.New("unknown patch format")
+}
+
+// IPS 记录 [偏移 3byte][长度 2byte][数据] 长度为 0 时为 RLE [长度 2byte][值 1byte] 均为大端
+// EOF 之后可能有 3byte 的截断长度
+func ApplyIPS(data []byte, patch []byte) ([]byte, error) {
+	res := append([]byte(nil), data...)
+	pos := len(IPSMagic)
+	for {
+		if pos+3 > len(patch) {
+			return nil, ErrPatchTruncated
+		}
+		if bytes.Equal(patch[pos:pos+3], IPSEnd) {
+			pos += 3
+			break
+		}
+		if pos+5 > len(patch) {
+			return nil, ErrPatchTruncated
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(binary.BigEndian.Uint16(patch[pos+3:]))
+		pos += 5
+		var chunk []byte
+		if size == 0 {
+			if pos+3 > len(patch) {
+				return nil, ErrPatchTruncated
+			}
+			size = int(binary.BigEndian.Uint16(patch[pos:]))
+			chunk = bytes.Repeat(patch[pos+2:pos+3], size)
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, ErrPatchTruncated
+			}
+			chunk = patch[pos : pos+size]
+			pos += size
+		}
+		for len(res) < offset+size {
+			res = append(res, 0)
+		}
+		copy(res[offset:], chunk)
+	}
+	if pos+3 <= len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(res) {
+			res = res[:size]
+		}
+	}
+	return res, nil
+}
+
+// UPS 与 BPS 共用的变长整数与 CRC32 校验
+type PatchReader struct {
+	Data []byte
+	Pos  int
+	Err  error
+}
+
+func (r *PatchReader) ReadUint8() uint8 {
+	if r.Pos >= len(r.Data) {
+		r.Err = ErrPatchTruncated
+		return 0
+	}
+	r.Pos++
+	return r.Data[r.Pos-1]
+}
+
+func (r *PatchReader) ReadNumber() int {
+	res, shift := 0, 1
+	for r.Err == nil {
+		val := r.ReadUint8()
+		res += int(val&0x7F) * shift
+		if val&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		res += shift
+		if res > PatchNumberLimit {
+			break
+		}
+	}
+	if res > PatchNumberLimit && r.Err == nil {
+		r.Err = fmt.Errorf("%w: number %d out of range", ErrPatchInvalid, res)
+	}
+	if r.Err != nil {
+		return 0
+	}
+	return res
+}
+
+// 末尾 12byte 为源文件 目标文件 补丁自身 (不含最后 4byte) 的 CRC32 不匹配时返回 ErrPatchCrc
+func CheckPatchCrc(kind string, source, target, patch []byte) error {
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return fmt.Errorf("%w: %s patch", ErrPatchCrc, kind)
+	}
+	if crc32.ChecksumIEEE(source) != binary.LittleEndian.Uint32(footer) {
+		return fmt.Errorf("%w: %s source, rom may be wrong version", ErrPatchCrc, kind)
+	}
+	if crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(footer[4:]) {
+		return fmt.Errorf("%w: %s target", ErrPatchCrc, kind)
+	}
+	return nil
+}
+
+// UPS 记录 [相对偏移][与源数据异或的字节 以 0 结尾] 校验不通过时同时返回结果与 ErrPatchCrc
+func ApplyUPS(data []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(UPSMagic)+12 {
+		return nil, ErrPatchTruncated
+	}
+	reader := &PatchReader{Data: patch[:len(patch)-12], Pos: len(UPSMagic)}
+	reader.ReadNumber() // 源文件大小
+	res := make([]byte, reader.ReadNumber())
+	copy(res, data)
+	pos := 0
+	for reader.Err == nil && reader.Pos < len(reader.Data) {
+		pos += reader.ReadNumber()
+		if pos > len(res) {
+			return nil, fmt.Errorf("%w: ups offset out of range", ErrPatchInvalid)
+		}
+		for reader.Err == nil {
+			val := reader.ReadUint8()
+			if val == 0 {
+				pos++
+				break
+			}
+			if pos < len(res) {
+				res[pos] ^= val
+			}
+			pos++
+		}
+	}
+	if reader.Err != nil {
+		return nil, reader.Err
+	}
+	return res, CheckPatchCrc("ups", data, res, patch)
+}
+
+// BPS 每个操作为 [长度-1 << 2 | 类型] 类型 0 复制源数据同位置 1 读取补丁数据
+// 2 从源数据相对位置复制 3 从目标数据相对位置复制
+func ApplyBPS(data []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(BPSMagic)+12 {
+		return nil, ErrPatchTruncated
+	}
+	reader := &PatchReader{Data: patch[:len(patch)-12], Pos: len(BPSMagic)}
+	reader.ReadNumber() // 源文件大小
+	res := make([]byte, reader.ReadNumber())
+	meta := reader.ReadNumber() // 跳过元数据
+	if reader.Err == nil && meta > len(reader.Data)-reader.Pos {
+		return nil, ErrPatchTruncated
+	}
+	reader.Pos += meta
+	out, sourceRel, targetRel := 0, 0, 0
+	for reader.Err == nil && reader.Pos < len(reader.Data) {
+		action := reader.ReadNumber()
+		length := action>>2 + 1
+		if out+length > len(res) {
+			return nil, fmt.Errorf("%w: bps write out of range", ErrPatchInvalid)
+		}
+		switch action & 3 {
+		case 0:
+			if out+length > len(data) {
+				return nil, fmt.Errorf("%w: bps source read out of range", ErrPatchInvalid)
+			}
+			copy(res[out:], data[out:out+length])
+		case 1:
+			for i := 0; i < length && reader.Err == nil; i++ {
+				res[out+i] = reader.ReadUint8()
+			}
+		case 2:
+			sourceRel += RelOffset(reader.ReadNumber())
+			if sourceRel < 0 || sourceRel+length > len(data) {
+				return nil, fmt.Errorf("%w: bps source copy out of range", ErrPatchInvalid)
+			}
+			copy(res[out:], data[sourceRel:sourceRel+length])
+			sourceRel += length
+		case 3: // 可能与写入区域重叠 需要逐字节复制
+			targetRel += RelOffset(reader.ReadNumber())
+			if targetRel < 0 || targetRel >= out {
+				return nil, fmt.Errorf("%w: bps target copy out of range", ErrPatchInvalid)
+			}
+			for i := 0; i < length; i++ {
+				res[out+i] = res[targetRel]
+				targetRel++
+			}
+		}
+		out += length
+	}
+	if reader.Err != nil {
+		return nil, reader.Err
+	}
+	return res, CheckPatchCrc("bps", data, res, patch)
+}
+
+// 最低位为符号位
+func RelOffset(val int) int {
+	if val&1 != 0 {
+		return -(val >> 1)
+	}
+	return val >> 1
+}
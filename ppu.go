@@ -1,15 +1,13 @@
-package main
+package nes
 
 import (
 	"fmt"
 	"image/color"
-
-	"github.com/hajimehoshi/ebiten/v2"
 )
 
 var (
-	Palette      [64]color.RGBA
-	MirrorLookup = [4][4]uint16{
+	DefaultPalette = InitPalette() // 只读 每个 PPU 持有自己的一份颜色
+	MirrorLookup   = [4][4]uint16{
 		{0, 0, 1, 1},
 		{0, 1, 0, 1},
 		{0, 0, 0, 0},
@@ -25,8 +23,8 @@ func MirrorAddr(mode uint8, addr uint16) uint16 {
 	return 0x2000 + MirrorLookup[mode][table]*0x0400 + offset
 }
 
-// 使用调色盘，颜色是固定的
-func InitPalette() {
+// 默认的调色盘，颜色是固定的 可以通过 LoadPalette 读取其他调色盘
+func InitPalette() [64]color.RGBA {
 	colors := []uint32{
 		0x666666, 0x002A88, 0x1412A7, 0x3B00A4, 0x5C007E, 0x6E0040, 0x6C0600, 0x561D00,
 		0x333500, 0x0B4800, 0x005200, 0x004F08, 0x00404D, 0x000000, 0x000000, 0x000000,
@@ -37,12 +35,26 @@ func InitPalette() {
 		0xFFFEFF, 0xC0DFFF, 0xD3D2FF, 0xE8C8FF, 0xFBC2FF, 0xFEC4EA, 0xFECCC5, 0xF7D8A5,
 		0xE4E594, 0xCFEF96, 0xBDF4AB, 0xB3F3CC, 0xB5EBF2, 0xB8B8B8, 0x000000, 0x000000,
 	}
+	res := [64]color.RGBA{}
 	for i, c := range colors {
 		r := uint8(c >> 16)
 		g := uint8(c >> 8)
 		b := uint8(c)
-		Palette[i] = color.RGBA{R: r, G: g, B: b, A: 0xFF}
+		res[i] = color.RGBA{R: r, G: g, B: b, A: 0xFF}
+	}
+	return res
+}
+
+// 读取 .pal 调色盘文件 64 个颜色每个 3byte RGB 部分文件包含强调色 只使用前 64 个
+func LoadPalette(data []byte) ([64]color.RGBA, error) {
+	res := [64]color.RGBA{}
+	if len(data) < 64*3 {
+		return res, fmt.Errorf("%w: palette", ErrTruncated)
+	}
+	for i := range res {
+		res[i] = color.RGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xFF}
 	}
+	return res, nil
 }
 
 type PPU struct {
@@ -52,10 +64,16 @@ type PPU struct {
 	Frame    uint64 // 绘制的多少帧了
 	// 一些静态变量
 	Palette   [32]uint8       // 调色盘
+	Colors    [64]color.RGBA  `state:"-"` // 调色盘索引对应的颜色 由前端设置
 	NameTable [2 * 1024]uint8 // tile显示的样子
 	OamData   [256]uint8      // 精灵属性数据
-	FrontBuff *ebiten.Image   // 绘图双缓冲
-	BackBuff  *ebiten.Image
+	// 绘图双缓冲 每个像素分别存储调色盘索引与 RGBA 颜色 不依赖图形环境
+	// 关闭渲染时不会写入像素 画面也属于状态 只保存索引 读档后用 UpdateRGBA 还原颜色
+	FrontIndex []uint8
+	BackIndex  []uint8
+	FrontRGBA  []uint8 `state:"-"`
+	BackRGBA   []uint8 `state:"-"`
+	FrameDone  uint64  // 完成的帧数 每次交换缓冲时增加
 	// PPU 寄存器
 	CurrVRam  uint16 // 当前的 vram 地址 15 Bit   用来读取要显示内容信息的地址
 	TempVRam  uint16 // 临时的 vram 地址 15 Bit   用来读取要显示内容信息的地址
@@ -106,10 +124,15 @@ type PPU struct {
 }
 
 func NewPPU(bus *Bus) *PPU {
-	ppu := &PPU{Bus: bus}
-	ppu.FrontBuff = ebiten.NewImage(256, 240)
-	ppu.BackBuff = ebiten.NewImage(256, 240)
-	InitPalette()
+	ppu := &PPU{Bus: bus, Colors: DefaultPalette}
+	ppu.FrontIndex = make([]uint8, Width*Height)
+	ppu.BackIndex = make([]uint8, Width*Height)
+	ppu.FrontRGBA = make([]uint8, Width*Height*4)
+	ppu.BackRGBA = make([]uint8, Width*Height*4)
+	for i := range ppu.FrontIndex { // 开启渲染前显示黑色 $0F
+		ppu.FrontIndex[i], ppu.BackIndex[i] = 0x0F, 0x0F
+	}
+	ppu.UpdateRGBA()
 	ppu.Reset()
 	return ppu
 }
@@ -120,6 +143,9 @@ func (p *PPU) Read(addr uint16, debug bool) uint8 {
 	case addr < 0x2000:
 		return p.Bus.Mapper.Read(addr, debug)
 	case addr < 0x3F00:
+		if nameTable, ok := p.Bus.Mapper.(MapperNameTable); ok {
+			return nameTable.ReadNameTable(addr)
+		}
 		mode := p.Bus.Cartridge.Mirror
 		return p.Bus.PPU.NameTable[MirrorAddr(mode, addr)%2048]
 	case addr < 0x4000:
@@ -136,6 +162,10 @@ func (p *PPU) Write(addr uint16, val uint8) {
 	case addr < 0x2000:
 		p.Bus.Mapper.Write(addr, val)
 	case addr < 0x3F00:
+		if nameTable, ok := p.Bus.Mapper.(MapperNameTable); ok {
+			nameTable.WriteNameTable(addr, val)
+			return
+		}
 		mode := p.Bus.Cartridge.Mirror
 		p.Bus.PPU.NameTable[MirrorAddr(mode, addr)%2048] = val
 	case addr < 0x4000:
@@ -400,8 +430,22 @@ func (p *PPU) NmiChange() {
 	p.NmiPre = nmi
 }
 
+// 按索引重新计算两个缓冲的颜色 修改 Colors 或读档后调用
+func (p *PPU) UpdateRGBA() {
+	for i, index := range p.FrontIndex {
+		c := p.Colors[index%64]
+		p.FrontRGBA[i*4], p.FrontRGBA[i*4+1], p.FrontRGBA[i*4+2], p.FrontRGBA[i*4+3] = c.R, c.G, c.B, c.A
+	}
+	for i, index := range p.BackIndex {
+		c := p.Colors[index%64]
+		p.BackRGBA[i*4], p.BackRGBA[i*4+1], p.BackRGBA[i*4+2], p.BackRGBA[i*4+3] = c.R, c.G, c.B, c.A
+	}
+}
+
 func (p *PPU) SetVBlank() {
-	p.FrontBuff, p.BackBuff = p.BackBuff, p.FrontBuff
+	p.FrontIndex, p.BackIndex = p.BackIndex, p.FrontIndex
+	p.FrontRGBA, p.BackRGBA = p.BackRGBA, p.FrontRGBA
+	p.FrameDone++
 	p.NmiOccur = true
 	p.NmiChange()
 }
@@ -520,8 +564,12 @@ func (p *PPU) RenderPixel() {
 			color0 = bg
 		}
 	}
-	c := Palette[p.ReadPalette(uint16(color0))%64]
-	p.BackBuff.Set(x, y, c)
+	index := p.ReadPalette(uint16(color0)) % 64
+	c := p.Colors[index]
+	offset := y*Width + x
+	p.BackIndex[offset] = index
+	rgba := p.BackRGBA[offset*4 : offset*4+4]
+	rgba[0], rgba[1], rgba[2], rgba[3] = c.R, c.G, c.B, c.A
 }
 
 func (p *PPU) FetchSpritePattern(i, row int) uint32 {
@@ -678,6 +726,11 @@ func (p *PPU) Step() {
 			} // 渲染完一行恢复 x
 			if p.Cycle == 257 {
 				p.CopyX()
+			} // 背景使用 $0000 精灵使用 $1000 时 A12 在这附近产生上升沿
+			if p.Cycle == 260 {
+				if scanline, ok := p.Bus.Mapper.(MapperScanline); ok {
+					scanline.Scanline()
+				}
 			}
 		}
 	}
@@ -0,0 +1,166 @@
+package nes
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// 倒带 每 Interval 帧保存一个快照 按住倒带键时每次后退一帧
+// 后退时读取目标帧之前最近的快照 再用记录的输入重新运行到目标帧
+const (
+	RewindInterval = 5
+	RewindLimit    = 32 * 1024 * 1024
+)
+
+// 最新的快照完整保存在 Rewind.Current 中 其余快照只保存与后一个快照的异或差分并压缩
+// 这样丢弃最旧的快照时不需要重新计算 后退时从最新的快照依次还原
+type Snapshot struct {
+	Frame int
+	Delta []byte // 与后一个快照异或后 flate 压缩的数据 最新的快照为 nil
+	Full  bool   // 长度与后一个快照不同时保存压缩后的完整数据
+}
+
+type Rewind struct {
+	Interval  int
+	Limit     int // 快照占用的内存上限 超过时丢弃最旧的快照
+	Frame     int // 已经运行的帧数
+	Size      int // 当前快照占用的内存
+	Snapshots []*Snapshot
+	Current   []byte     // 最新快照的完整数据
+	Inputs    [][2]uint8 // 从最旧的快照开始每一帧的输入
+}
+
+func NewRewind(interval, limit int) *Rewind {
+	return &Rewind{Interval: interval, Limit: limit}
+}
+
+// 读档或重启后之前的快照与输入不再有效
+func (r *Rewind) Clear() {
+	r.Snapshots = nil
+	r.Current = nil
+	r.Inputs = nil
+	r.Size = 0
+}
+
+// 代替 Console.StepFrame 运行一帧 并记录输入与快照
+func (r *Rewind) Step(console *Console) error {
+	// 后退之后当前帧可能已经有快照
+	if len(r.Snapshots) == 0 || r.Frame%r.Interval == 0 && r.Snapshots[len(r.Snapshots)-1].Frame < r.Frame {
+		if err := r.Push(console); err != nil {
+			return err
+		}
+	}
+	r.Inputs = append(r.Inputs, [2]uint8{console.Buttons(0), console.Buttons(1)})
+	r.Frame++
+	return console.StepFrame()
+}
+
+// 重启与换面等命令不属于输入 回放时无法重现 在执行命令之后 运行这一帧之前保存快照
+// 这样后退时不会从命令之前的快照运行到命令之后
+func (r *Rewind) Mark(console *Console) error {
+	if len(r.Snapshots) > 0 && r.Snapshots[len(r.Snapshots)-1].Frame >= r.Frame {
+		return nil
+	}
+	return r.Push(console)
+}
+
+func (r *Rewind) Push(console *Console) error {
+	data, err := console.Bus.Snapshot()
+	if err != nil {
+		return err
+	}
+	if len(r.Snapshots) > 0 {
+		last := r.Snapshots[len(r.Snapshots)-1]
+		last.Full = len(r.Current) != len(data)
+		if last.Full {
+			last.Delta = Compress(r.Current)
+		} else {
+			last.Delta = Compress(XorDelta(r.Current, data))
+		}
+		r.Size += len(last.Delta) - len(r.Current)
+	}
+	r.Snapshots = append(r.Snapshots, &Snapshot{Frame: r.Frame})
+	r.Current = data
+	r.Size += len(data)
+	for r.Size > r.Limit && len(r.Snapshots) > 1 {
+		r.Size -= len(r.Snapshots[0].Delta)
+		r.Inputs = r.Inputs[r.Snapshots[1].Frame-r.Snapshots[0].Frame:]
+		r.Snapshots = r.Snapshots[1:]
+	}
+	return nil
+}
+
+// 丢弃最新的快照 用差分还原前一个快照
+func (r *Rewind) Pop() error {
+	r.Size -= len(r.Current)
+	r.Snapshots = r.Snapshots[:len(r.Snapshots)-1]
+	if len(r.Snapshots) == 0 {
+		r.Current = nil
+		return nil
+	}
+	last := r.Snapshots[len(r.Snapshots)-1]
+	delta, err := Decompress(last.Delta)
+	if err != nil {
+		return err
+	}
+	if !last.Full {
+		delta = XorDelta(r.Current, delta)
+	}
+	r.Size += len(delta) - len(last.Delta)
+	r.Current, last.Delta = delta, nil
+	return nil
+}
+
+// 后退一帧 没有更早的快照时返回 false
+// 需要从快照至少运行一帧才能得到画面 所以使用目标帧之前的快照
+func (r *Rewind) Back(console *Console) (bool, error) {
+	target := r.Frame - 1
+	for len(r.Snapshots) > 0 && r.Snapshots[len(r.Snapshots)-1].Frame >= target {
+		if len(r.Snapshots) == 1 {
+			return false, nil
+		}
+		if err := r.Pop(); err != nil {
+			return false, err
+		}
+	}
+	if len(r.Snapshots) == 0 {
+		return false, nil
+	}
+	if err := console.Bus.Restore(r.Current); err != nil {
+		return false, err
+	}
+	start := r.Snapshots[0].Frame
+	for frame := r.Snapshots[len(r.Snapshots)-1].Frame; frame < target; frame++ {
+		input := r.Inputs[frame-start]
+		console.SetButtons(0, input[0])
+		console.SetButtons(1, input[1])
+		if err := console.StepFrame(); err != nil {
+			return false, err
+		}
+	}
+	r.Inputs = r.Inputs[:target-start]
+	r.Frame = target
+	return true, nil
+}
+
+// a b 长度必须相同
+func XorDelta(a, b []byte) []byte {
+	res := make([]byte, len(b))
+	for i := range b {
+		res[i] = a[i] ^ b[i]
+	}
+	return res
+}
+
+func Compress(data []byte) []byte {
+	buff := &bytes.Buffer{}
+	writer, _ := flate.NewWriter(buff, flate.BestSpeed)
+	writer.Write(data)
+	writer.Close()
+	return buff.Bytes()
+}
+
+func Decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,117 @@
+package nes
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 存档栏位 1-10 对应前端的 F1-F10
+const (
+	SlotCount   = 10
+	ThumbWidth  = Width / 2
+	ThumbHeight = Height / 2
+)
+
+type Slot struct {
+	Index int
+	Time  time.Time   // 保存时间 栏位为空时为零值
+	Thumb *image.RGBA // 缩略图 读取失败时为 nil
+}
+
+func (s *Slot) Empty() bool {
+	return s.Time.IsZero()
+}
+
+// 栏位存档与 rom 同名 例如 game.3.state 缩略图为 game.3.png
+func (c *Cartridge) SlotPath(index int) string {
+	return fmt.Sprintf("%s.%d.state", strings.TrimSuffix(c.Path, filepath.Ext(c.Path)), index)
+}
+
+func (c *Cartridge) ThumbPath(index int) string {
+	return strings.TrimSuffix(c.SlotPath(index), ".state") + ".png"
+}
+
+// 隔行隔列取样缩小为一半
+func Thumbnail(rgba []uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ThumbWidth, ThumbHeight))
+	for y := 0; y < ThumbHeight; y++ {
+		for x := 0; x < ThumbWidth; x++ {
+			copy(img.Pix[(y*ThumbWidth+x)*4:], rgba[(y*2*Width+x*2)*4:(y*2*Width+x*2)*4+4])
+		}
+	}
+	return img
+}
+
+func (c *Console) SaveSlot(index int) error {
+	if c.Bus == nil {
+		return ErrNoRom
+	}
+	if index < 1 || index > SlotCount {
+		return fmt.Errorf("bad slot %d", index)
+	}
+	cartridge := c.Bus.Cartridge
+	if err := c.Bus.SaveStateFile(cartridge.SlotPath(index)); err != nil {
+		return err
+	}
+	buff := &bytes.Buffer{}
+	if err := png.Encode(buff, Thumbnail(c.Framebuffer())); err != nil {
+		return err
+	}
+	return os.WriteFile(cartridge.ThumbPath(index), buff.Bytes(), 0644)
+}
+
+func (c *Console) LoadSlot(index int) error {
+	if c.Bus == nil {
+		return ErrNoRom
+	}
+	if index < 1 || index > SlotCount {
+		return fmt.Errorf("bad slot %d", index)
+	}
+	return c.Bus.LoadStateFile(c.Bus.Cartridge.SlotPath(index))
+}
+
+// 读取栏位的时间与缩略图 不修改模拟器状态 栏位不存在时返回空栏位
+func (c *Console) ReadSlot(index int) (*Slot, error) {
+	slot := &Slot{Index: index}
+	if c.Bus == nil {
+		return slot, ErrNoRom
+	}
+	file, err := os.Open(c.Bus.Cartridge.SlotPath(index))
+	if os.IsNotExist(err) {
+		return slot, nil
+	}
+	if err != nil {
+		return slot, err
+	}
+	defer file.Close()
+	state, err := ReadStateFile(file)
+	if err != nil {
+		return slot, err
+	}
+	if state.RomHash != c.Bus.Cartridge.Hash {
+		return slot, ErrStateRom
+	}
+	slot.Time = time.Unix(state.Time, 0)
+	data, err := os.ReadFile(c.Bus.Cartridge.ThumbPath(index))
+	if err != nil {
+		return slot, nil // 缺少缩略图不影响读档
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return slot, nil
+	}
+	thumb := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			thumb.Set(x, y, img.At(x, y))
+		}
+	}
+	slot.Thumb = thumb
+	return slot, nil
+}
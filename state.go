@@ -0,0 +1,289 @@
+package nes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// 即时存档格式 [魔数 4byte][版本 2byte 小端][gob 编码的 StateFile]
+// 每个字段按 "Bus.CPU.PC" 这样的路径单独编码 读取时忽略不认识的字段 缺少的字段保持原值
+// 这样新旧版本的存档可以互相读取 字段含义变化时增加 StateVersion 并在 LoadState 中做转换
+const (
+	StateMagic   = "NESS"
+	StateVersion = 1
+)
+
+var (
+	ErrStateRom     = errors.New("save state belongs to another rom")
+	ErrStateVersion = errors.New("save state is from a newer version")
+)
+
+type StateFile struct {
+	RomHash [20]byte
+	Time    int64 // 保存时的 unix 时间
+	Fields  map[string][]byte
+}
+
+// 不需要保存的字段类型 它们由其他部分保存或者在加载 rom 时重建
+var StateSkipTypes = map[reflect.Type]bool{
+	reflect.TypeOf((*Bus)(nil)):       true,
+	reflect.TypeOf((*Cartridge)(nil)): true,
+}
+
+type State struct {
+	Fields map[string][]byte
+	Err    error // 第一个出错的字段
+}
+
+func NewState() *State {
+	return &State{Fields: make(map[string][]byte)}
+}
+
+func (s *State) SetErr(name string, err error) {
+	if s.Err == nil {
+		s.Err = fmt.Errorf("state field %s: %w", name, err)
+	}
+}
+
+func (s *State) Put(name string, val reflect.Value) {
+	buff := &bytes.Buffer{}
+	if err := gob.NewEncoder(buff).EncodeValue(val); err != nil {
+		s.SetErr(name, err)
+		return
+	}
+	s.Fields[name] = buff.Bytes()
+}
+
+// 字段不存在时保持原值
+func (s *State) Get(name string, val reflect.Value) {
+	data, ok := s.Fields[name]
+	if !ok {
+		return
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(val.Addr()); err != nil {
+		s.SetErr(name, err)
+	}
+}
+
+// 遍历结构体中需要保存的导出字段 指针与内嵌结构体会递归展开
+// 跳过函数 接口 StateSkipTypes 以及带有 `state:"-"` 标签的字段
+func WalkState(prefix string, val reflect.Value, fn func(name string, val reflect.Value)) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("state") == "-" || StateSkipTypes[field.Type] {
+			continue
+		}
+		name := prefix + field.Name
+		value := val.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Ptr:
+			if !value.IsNil() && field.Type.Elem().Kind() == reflect.Struct {
+				WalkState(name+".", value, fn)
+			}
+		case reflect.Struct:
+			WalkState(name+".", value, fn)
+		case reflect.Func, reflect.Interface, reflect.Chan, reflect.UnsafePointer:
+		case reflect.Array, reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.Func {
+				fn(name, value)
+			}
+		default:
+			fn(name, value)
+		}
+	}
+}
+
+func (s *State) Save(prefix string, v interface{}) {
+	WalkState(prefix, reflect.ValueOf(v), s.Put)
+}
+
+func (s *State) Load(prefix string, v interface{}) {
+	WalkState(prefix, reflect.ValueOf(v), s.Get)
+}
+
+// 卡带中只有镜像 CHR-RAM 与 FDS 磁盘会变化 ROM 部分不需要保存
+func (c *Cartridge) SaveState(s *State) {
+	s.Put("Cartridge.Mirror", reflect.ValueOf(&c.Mirror).Elem())
+	if c.ChrRam > 0 {
+		s.Put("Cartridge.CHR", reflect.ValueOf(&c.CHR).Elem())
+	}
+	if len(c.Disk) > 0 {
+		s.Put("Cartridge.Disk", reflect.ValueOf(&c.Disk).Elem())
+	}
+}
+
+func (c *Cartridge) LoadState(s *State) {
+	s.Get("Cartridge.Mirror", reflect.ValueOf(&c.Mirror).Elem())
+	if c.ChrRam > 0 {
+		s.Get("Cartridge.CHR", reflect.ValueOf(&c.CHR).Elem())
+	}
+	if len(c.Disk) > 0 {
+		s.Get("Cartridge.Disk", reflect.ValueOf(&c.Disk).Elem())
+	}
+}
+
+// Bus 中包含 CPU PPU 与手柄 mapper 单独保存
+func (c *Bus) SaveState(w io.Writer) error {
+	state := NewState()
+	state.Save("Bus.", c)
+	state.Save("Mapper.", c.Mapper)
+	c.Cartridge.SaveState(state)
+	if state.Err != nil {
+		return state.Err
+	}
+	writer := bufio.NewWriter(w)
+	writer.WriteString(StateMagic)
+	if err := binary.Write(writer, binary.LittleEndian, uint16(StateVersion)); err != nil {
+		return err
+	}
+	err := gob.NewEncoder(writer).Encode(&StateFile{RomHash: c.Cartridge.Hash, Time: time.Now().Unix(),
+		Fields: state.Fields})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// 只读取存档文件 不修改模拟器状态
+func ReadStateFile(r io.Reader) (*StateFile, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(StateMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, fmt.Errorf("%w: save state", ErrTruncated)
+	}
+	if string(magic) != StateMagic {
+		return nil, fmt.Errorf("%w: not save state", ErrBadMagic)
+	}
+	version := uint16(0)
+	if err := binary.Read(reader, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("%w: save state", ErrTruncated)
+	}
+	if version > StateVersion { // 旧版本无法知道新版本字段的含义
+		return nil, fmt.Errorf("%w: %d > %d", ErrStateVersion, version, StateVersion)
+	}
+	file := &StateFile{}
+	if err := gob.NewDecoder(reader).Decode(file); err != nil {
+		return nil, fmt.Errorf("save state version %d: %w", version, err)
+	}
+	return file, nil
+}
+
+// 先校验魔数与 rom 哈希 再覆盖当前状态
+func (c *Bus) LoadState(r io.Reader) error {
+	file, err := ReadStateFile(r)
+	if err != nil {
+		return err
+	}
+	if file.RomHash != c.Cartridge.Hash {
+		return ErrStateRom
+	}
+	state := &State{Fields: file.Fields}
+	return c.ApplyState(state)
+}
+
+// 不带文件头的完整状态 字段按名字排序拼接 相同状态总是得到相同的数据 用于倒带时做差分
+// [名字长度 2byte][名字][数据长度 4byte][数据]...
+func (c *Bus) Snapshot() ([]byte, error) {
+	state := NewState()
+	state.Save("Bus.", c)
+	state.Save("Mapper.", c.Mapper)
+	c.Cartridge.SaveState(state)
+	if state.Err != nil {
+		return nil, state.Err
+	}
+	names := make([]string, 0, len(state.Fields))
+	for name := range state.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buff := &bytes.Buffer{}
+	for _, name := range names {
+		binary.Write(buff, binary.LittleEndian, uint16(len(name)))
+		buff.WriteString(name)
+		binary.Write(buff, binary.LittleEndian, uint32(len(state.Fields[name])))
+		buff.Write(state.Fields[name])
+	}
+	return buff.Bytes(), nil
+}
+
+func (c *Bus) Restore(data []byte) error {
+	state, err := ParseSnapshot(data)
+	if err != nil {
+		return err
+	}
+	return c.ApplyState(state)
+}
+
+func ParseSnapshot(data []byte) (*State, error) {
+	state := NewState()
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(binary.LittleEndian.Uint16(data))+4 {
+			return nil, fmt.Errorf("%w: snapshot", ErrTruncated)
+		}
+		size := int(binary.LittleEndian.Uint16(data))
+		name := string(data[2 : 2+size])
+		data = data[2+size:]
+		size = int(binary.LittleEndian.Uint32(data))
+		if len(data) < 4+size {
+			return nil, fmt.Errorf("%w: snapshot", ErrTruncated)
+		}
+		state.Fields[name] = data[4 : 4+size]
+		data = data[4+size:]
+	}
+	return state, nil
+}
+
+// 读取失败时恢复到之前的快照 不会留下只读取了一部分的状态
+func (c *Bus) ApplyState(state *State) error {
+	backup, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err = c.LoadFields(state); err != nil {
+		if prev, parseErr := ParseSnapshot(backup); parseErr == nil {
+			c.LoadFields(prev)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Bus) LoadFields(state *State) error {
+	state.Load("Bus.", c)
+	state.Load("Mapper.", c.Mapper)
+	c.Cartridge.LoadState(state)
+	c.PPU.UpdateRGBA()
+	return state.Err
+}
+
+func (c *Bus) SaveStateFile(path string) error {
+	buff := &bytes.Buffer{}
+	if err := c.SaveState(buff); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buff.Bytes(), 0644)
+}
+
+func (c *Bus) LoadStateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.LoadState(bytes.NewReader(data))
+}
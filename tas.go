@@ -0,0 +1,217 @@
+package nes
+
+// TAS 编辑 在录像上直接修改任意一帧的输入
+// 绿区保存运行过的帧开始前的快照 修改某一帧后只需要从之前最近的快照重新运行
+// 绿区与倒带一样有内存上限 书签最多 BookmarkCount 个
+const (
+	GreenzoneInterval = 10
+	GreenzoneLimit    = 64 * 1024 * 1024
+	BookmarkCount     = 10
+)
+
+type TAS struct {
+	Movie     *Movie
+	Frame     int            // 已经运行的帧数 即下一帧的编号
+	Greenzone map[int][]byte // 第 n 帧开始前压缩后的快照 只保存 GreenzoneInterval 的整数倍 起点与书签
+	Limit     int            // 绿区占用的内存上限 超过时丢弃离当前帧最远的快照
+	Size      int            // 绿区当前占用的内存
+	End       int            // 之前的帧都使用当前的输入运行过 延迟帧记录在 Movie.Lag 中
+	Bookmarks [BookmarkCount]*Bookmark
+}
+
+// 书签保存一个分支的全部输入 读取书签即切换到这个分支
+type Bookmark struct {
+	Frame  int
+	Frames []MovieFrame
+	Lag    []bool
+	State  []byte
+}
+
+// console 当前处于录像的第 frame 帧开始前
+func NewTAS(console *Console, movie *Movie, frame int) (*TAS, error) {
+	t := &TAS{Movie: movie, Frame: frame, Greenzone: make(map[int][]byte), Limit: GreenzoneLimit, End: frame}
+	for len(movie.Frames) < frame {
+		movie.Frames = append(movie.Frames, MovieFrame{})
+	}
+	if err := t.Capture(console); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TAS) Capture(console *Console) error {
+	data, err := console.Bus.Snapshot()
+	if err != nil {
+		return err
+	}
+	t.Put(t.Frame, Compress(data))
+	return nil
+}
+
+// 加入快照 超过内存上限时丢弃离当前帧最远的快照 当前帧的快照总是保留
+func (t *TAS) Put(frame int, state []byte) {
+	t.Drop(frame)
+	t.Greenzone[frame] = state
+	t.Size += len(state)
+	for t.Size > t.Limit && len(t.Greenzone) > 1 {
+		farthest, dist := -1, -1
+		for key := range t.Greenzone {
+			if d := Abs(key - t.Frame); key != frame && d > dist {
+				farthest, dist = key, d
+			}
+		}
+		t.Drop(farthest)
+	}
+}
+
+func (t *TAS) Drop(frame int) {
+	if state, ok := t.Greenzone[frame]; ok {
+		t.Size -= len(state)
+		delete(t.Greenzone, frame)
+	}
+}
+
+func (t *TAS) Restore(console *Console, frame int) error {
+	data, err := Decompress(t.Greenzone[frame])
+	if err != nil {
+		return err
+	}
+	if err = console.Bus.Restore(data); err != nil {
+		return err
+	}
+	t.Frame = frame
+	return nil
+}
+
+// 小于等于 frame 的最近的快照 没有时返回 -1
+func (t *TAS) Nearest(frame int) int {
+	res := -1
+	for key := range t.Greenzone {
+		if key <= frame && key > res {
+			res = key
+		}
+	}
+	return res
+}
+
+// 从进入 TAS 的帧开始 到最后运行过的帧都使用当前的输入运行过
+func (t *TAS) InGreenzone(frame int) bool {
+	return t.Nearest(frame) >= 0 && frame <= t.End
+}
+
+// 运行一帧 超出录像长度时添加空白帧
+func (t *TAS) RunFrame(console *Console) error {
+	for len(t.Movie.Frames) <= t.Frame {
+		t.Movie.Frames = append(t.Movie.Frames, MovieFrame{})
+	}
+	if err := t.Movie.Apply(console, t.Frame); err != nil {
+		return err
+	}
+	if err := console.StepFrame(); err != nil {
+		return err
+	}
+	t.Movie.SetLag(t.Frame, console.Bus.Lag)
+	t.Frame++
+	t.End = Max(t.End, t.Frame)
+	if _, ok := t.Greenzone[t.Frame]; !ok && t.Frame%GreenzoneInterval == 0 {
+		return t.Capture(console)
+	}
+	return nil
+}
+
+// 读取 frame 之前最近的快照 早于进入 TAS 的帧时从录像开头开始
+func (t *TAS) Reload(console *Console, frame int) error {
+	if nearest := t.Nearest(frame); nearest >= 0 {
+		return t.Restore(console, nearest)
+	}
+	if err := t.Movie.Start(console); err != nil {
+		return err
+	}
+	t.Frame = 0
+	return t.Capture(console)
+}
+
+// 跳到第 target 帧开始前 向前跳时从最近的快照重新运行
+func (t *TAS) Seek(console *Console, target int) error {
+	if target < 0 {
+		target = 0
+	}
+	if target < t.Frame || t.Nearest(target) > t.Frame {
+		if err := t.Reload(console, target); err != nil {
+			return err
+		}
+	}
+	for t.Frame < target {
+		if err := t.RunFrame(console); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 第 frame 帧的输入改变 之后的快照全部失效 当前位置在之后时重新运行到当前位置
+func (t *TAS) Invalidate(console *Console, frame int) error {
+	for key := range t.Greenzone {
+		if key > frame {
+			t.Drop(key)
+		}
+	}
+	if t.End > frame {
+		t.End = frame
+	}
+	if len(t.Movie.Lag) > frame {
+		t.Movie.Lag = t.Movie.Lag[:frame]
+	}
+	if t.Frame <= frame {
+		return nil
+	}
+	t.Movie.AddRerecord()
+	current := t.Frame
+	if err := t.Reload(console, frame); err != nil {
+		return err
+	}
+	return t.Seek(console, current)
+}
+
+func (t *TAS) Toggle(console *Console, frame, player int, button uint8) error {
+	for len(t.Movie.Frames) <= frame {
+		t.Movie.Frames = append(t.Movie.Frames, MovieFrame{})
+	}
+	t.Movie.Frames[frame].Buttons[player] ^= 1 << button
+	return t.Invalidate(console, frame)
+}
+
+func (t *TAS) SetBookmark(console *Console, index int) error {
+	data, err := console.Bus.Snapshot()
+	if err != nil {
+		return err
+	}
+	state := Compress(data)
+	t.Put(t.Frame, state)
+	t.Bookmarks[index] = &Bookmark{Frame: t.Frame, Frames: append([]MovieFrame(nil), t.Movie.Frames...),
+		Lag: append([]bool(nil), t.Movie.Lag...), State: state}
+	return nil
+}
+
+// 切换到书签的分支 两个分支第一个不同的帧之后的绿区失效
+func (t *TAS) LoadBookmark(console *Console, index int) (bool, error) {
+	bookmark := t.Bookmarks[index]
+	if bookmark == nil {
+		return false, nil
+	}
+	diff := 0
+	for diff < len(t.Movie.Frames) && diff < len(bookmark.Frames) && t.Movie.Frames[diff] == bookmark.Frames[diff] {
+		diff++
+	}
+	for key := range t.Greenzone {
+		if key > diff {
+			t.Drop(key)
+		}
+	}
+	t.Movie.Frames = append([]MovieFrame(nil), bookmark.Frames...)
+	t.Movie.Lag = append([]bool(nil), bookmark.Lag...)
+	t.End = bookmark.Frame
+	t.Put(bookmark.Frame, bookmark.State)
+	t.Movie.AddRerecord()
+	return true, t.Restore(console, bookmark.Frame)
+}
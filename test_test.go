@@ -2,7 +2,7 @@
 @author: sk
 @date: 2024/8/3
 */
-package main
+package nes
 
 import (
 	"fmt"
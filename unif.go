@@ -0,0 +1,143 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	UNIFMagic    = "UNIF"
+	UNIFHeadSize = 32 // 魔数 4byte 版本 4byte 其余保留
+)
+
+// UNIF 使用板子名称区分 mapper 这里将其映射到 iNES 的 mapper 号
+type UNIFBoard struct {
+	Mapper    uint16
+	Submapper uint8
+}
+
+var UNIFBoards = map[string]UNIFBoard{
+	// 官方板子
+	"NROM": {0, 0}, "NROM-128": {0, 0}, "NROM-256": {0, 0}, "RROM": {0, 0}, "RROM-128": {0, 0},
+	"UNROM": {2, 0}, "UOROM": {2, 0},
+	"CNROM": {3, 0},
+	"TBROM": {4, 0}, "TEROM": {4, 0}, "TFROM": {4, 0}, "TGROM": {4, 0}, "TKROM": {4, 0}, "TLROM": {4, 0},
+	"TNROM": {4, 0}, "TR1ROM": {4, 0}, "TSROM": {4, 0}, "TVROM": {4, 0}, "B4": {4, 0}, "HKROM": {4, 1},
+	"AMROM": {7, 0}, "ANROM": {7, 0}, "AN1ROM": {7, 0}, "AOROM": {7, 0},
+	"BNROM": {34, 0},
+	"GNROM": {66, 0}, "MHROM": {66, 0},
+	"BTR": {69, 0}, "JLROM": {69, 0}, "JSROM": {69, 0},
+	"TKSROM": {118, 0}, "TLSROM": {118, 0},
+	"TQROM": {119, 0},
+	"DEROM": {206, 0}, "DE1ROM": {206, 0}, "DRROM": {206, 0},
+	// 第三方板子
+	"COLORDREAMS-74*377": {11, 0},
+	"BANDAI-FCG-1":       {16, 4}, "BANDAI-FCG-2": {16, 4}, "BANDAI-LZ93D50+24C02": {16, 5},
+	"BANDAI-JUMP2": {153, 0}, "BANDAI-LZ93D50+24C01": {159, 0},
+	"JALECO-JF-23": {18, 0}, "JALECO-JF-24": {18, 0}, "JALECO-JF-25": {18, 0}, "JALECO-JF-27": {18, 0},
+	"JALECO-JF-29": {18, 0}, "JALECO-JF-37": {18, 0}, "JALECO-JF-40": {18, 0},
+	"NAMCOT-163": {19, 0}, "NAMCOT-3446": {76, 0}, "NAMCOT-3433": {88, 0}, "NAMCOT-3443": {88, 0},
+	"NAMCOT-3425": {95, 0}, "NAMCOT-3453": {154, 0},
+	"IREM-G101": {32, 0}, "IREM-H3001": {65, 0},
+	"TAITO-TC0190FMC": {33, 0}, "TAITO-TC0690FMC": {48, 0}, "TAITO-X1-005": {80, 0},
+	"AVE-NINA-01": {34, 1}, "NINA-001": {34, 1}, "NINA-03": {79, 0}, "NINA-06": {79, 0},
+	"CAMERICA-BF9093": {71, 0}, "CAMERICA-BF9097": {71, 1}, "CAMERICA-ALGN": {71, 0},
+	"MLT-ACTION52":     {228, 0},
+	"42in1ResetSwitch": {233, 0},
+}
+
+// 板子名称可能带有 NES- UNL- 等前缀
+var UNIFPrefixes = []string{"NES-", "HVC-", "UNL-", "BMC-", "BTL-"}
+
+func IsUNIF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(UNIFMagic))
+}
+
+func FindUNIFBoard(name string) (UNIFBoard, bool) {
+	if board, ok := UNIFBoards[name]; ok {
+		return board, true
+	}
+	for _, prefix := range UNIFPrefixes {
+		if board, ok := UNIFBoards[strings.TrimPrefix(name, prefix)]; ok && strings.HasPrefix(name, prefix) {
+			return board, true
+		}
+	}
+	return UNIFBoard{}, false
+}
+
+// UNIF 由若干 [类型 4byte][长度 4byte][数据] 的块组成
+// PRG 与 CHR 分别存储在 PRG0-PRGF 与 CHR0-CHRF 中 按编号顺序拼接
+func LoadUNIF(path string, data []byte) (*Cartridge, error) {
+	if len(data) < UNIFHeadSize {
+		return nil, fmt.Errorf("%w: unif header", ErrTruncated)
+	}
+	var prgs, chrs [16][]byte
+	cartridge := &Cartridge{Path: path}
+	boardName := ""
+	for data = data[UNIFHeadSize:]; len(data) >= 8; {
+		id := string(data[:4])
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+		if length > len(data) {
+			return nil, fmt.Errorf("%w: unif chunk %s", ErrTruncated, id)
+		}
+		chunk := data[:length]
+		data = data[length:]
+		switch {
+		case id == "MAPR":
+			boardName = CString(chunk)
+		case id == "NAME":
+			cartridge.Name = CString(chunk)
+		case id == "MIRR" && length > 0:
+			if chunk[0] <= MirrorSingle1 { // 4 为四屏 5 由 mapper 控制 保持默认
+				cartridge.Mirror = chunk[0]
+			}
+		case id == "BATR":
+			cartridge.Battery = true
+		case strings.HasPrefix(id, "PRG") || strings.HasPrefix(id, "CHR"):
+			index, ok := HexIndex(id[3])
+			if !ok { // 编号不是 0-F 时跳过 不能覆盖 PRG0 CHR0
+				cartridge.Warnings = append(cartridge.Warnings, fmt.Errorf("skip unif chunk %q", id))
+			} else if id[0] == 'P' {
+				prgs[index] = chunk
+			} else {
+				chrs[index] = chunk
+			}
+		}
+	}
+	board, ok := FindUNIFBoard(boardName)
+	if !ok {
+		return nil, &ErrUnsupportedMapper{Board: boardName}
+	}
+	cartridge.Mapper = board.Mapper
+	cartridge.Submapper = board.Submapper
+	cartridge.PRG = bytes.Join(prgs[:], nil)
+	cartridge.CHR = bytes.Join(chrs[:], nil)
+	if len(cartridge.CHR) == 0 { // 没有 CHR-ROM 使用 8k CHR-RAM
+		cartridge.ChrRam = 8 * 1024
+		cartridge.CHR = make([]byte, cartridge.ChrRam)
+	}
+	return cartridge, nil
+}
+
+// 以 0 结尾的字符串
+func CString(data []byte) string {
+	if index := bytes.IndexByte(data, 0); index >= 0 {
+		data = data[:index]
+	}
+	return string(data)
+}
+
+func HexIndex(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10, true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	}
+	return 0, false
+}
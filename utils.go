@@ -1,7 +1,22 @@
-package main
+package nes
 
-func HandleErr(err error) {
-	if err != nil {
-		panic(err)
+func Max(a, b int) int {
+	if a > b {
+		return a
 	}
+	return b
+}
+
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func Abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
 }